@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cleanupStatus records the outcome of the last cleanup run, for the expvar handler
+type cleanupStatus struct {
+	mu       sync.Mutex
+	lastRun  time.Time
+	duration time.Duration
+	deleted  map[string]int64
+	err      string
+}
+
+// snapshot returns a copy of the status which is safe to encode
+func (s *cleanupStatus) snapshot() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := make(map[string]int64, len(s.deleted))
+	for k, v := range s.deleted {
+		deleted[k] = v
+	}
+
+	return map[string]interface{}{
+		"last_run":    s.lastRun,
+		"duration_ms": s.duration.Milliseconds(),
+		"deleted":     deleted,
+		"error":       s.err,
+	}
+}
+
+// cleanupTask deletes up to batchSize rows, and returns how many it deleted
+type cleanupTask struct {
+	name string
+	fn   func(batchSize int) (int64, error)
+}
+
+// startCleanup launches the background scheduler which purges expired tokens and the
+// records which depend on them. It's tracked by app.wg and stops when the server
+// starts shutting down.
+func (app *application) startCleanup() {
+	app.background(func() {
+		ticker := time.NewTicker(app.config.cleanup.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				app.runCleanup()
+			case <-app.shutdown:
+				return
+			}
+		}
+	})
+}
+
+// runCleanup runs every cleanup task once. Tasks delete in batches, so no single query
+// holds locks on a large part of a table.
+func (app *application) runCleanup() {
+	// Sessions are cleaned up after tokens, since they're orphaned by token deletion
+	tasks := []cleanupTask{
+		{"tokens", app.models.Token.DeleteExpired},
+		{"sessions", app.models.Sessions.DeleteOrphaned},
+		{"jwt_denylist", app.models.DenyList.DeleteExpired},
+	}
+
+	start := time.Now()
+	deleted := make(map[string]int64)
+	var lastErr error
+
+	for _, task := range tasks {
+		for {
+			n, err := task.fn(app.config.cleanup.batchSize)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"task": task.name})
+				lastErr = err
+				break
+			}
+
+			deleted[task.name] += n
+
+			// Stop once a batch comes back short, or the server is shutting down
+			if n < int64(app.config.cleanup.batchSize) || app.isShuttingDown() {
+				break
+			}
+		}
+	}
+
+	duration := time.Since(start)
+
+	properties := map[string]string{"duration": duration.String()}
+	for name, n := range deleted {
+		properties[name] = strconv.FormatInt(n, 10)
+	}
+	app.logger.PrintInfo("expired records cleaned up", properties)
+
+	app.cleanupStatus.mu.Lock()
+	defer app.cleanupStatus.mu.Unlock()
+
+	app.cleanupStatus.lastRun = start
+	app.cleanupStatus.duration = duration
+	app.cleanupStatus.deleted = deleted
+	app.cleanupStatus.err = ""
+	if lastErr != nil {
+		app.cleanupStatus.err = lastErr.Error()
+	}
+}
+
+// isShuttingDown reports whether the server has started a graceful shutdown
+func (app *application) isShuttingDown() bool {
+	select {
+	case <-app.shutdown:
+		return true
+	default:
+		return false
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/ahojo/greenlight/internal/data"
+	"github.com/ahojo/greenlight/internal/jwt"
 )
 
 // Define a custom contextKey type, with the underlying type string
@@ -14,6 +15,16 @@ type contextKey string
 // We'll use this constant as the key for getting and setting user information in the request context
 const userContextKey = contextKey("user")
 
+// tokenContextKey is used to store the plaintext authentication token that was used to
+// authenticate the request, so it can be revoked when the user logs out.
+const tokenContextKey = contextKey("token")
+
+// apiKeyContextKey is used to store the API key that was used to authenticate the request
+const apiKeyContextKey = contextKey("apiKey")
+
+// claimsContextKey is used to store the claims of a signed (JWT) authentication token
+const claimsContextKey = contextKey("claims")
+
 // contextSetUser() method returns a new copy of the request with the provided User Struct added to the context
 // NOTE: userContextKey as the key
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
@@ -32,3 +43,47 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetToken() returns a new copy of the request with the plaintext authentication
+// token added to the context.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken() retrieves the plaintext authentication token from the request context.
+// It returns the empty string for anonymous requests.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// contextSetAPIKey() returns a new copy of the request with the API key added to the context
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey() retrieves the API key from the request context. It returns nil
+// when the request wasn't authenticated with an API key.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetClaims() returns a new copy of the request with the claims of the signed
+// token that authenticated it added to the context.
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetClaims() retrieves the signed token's claims from the request context. It
+// returns nil when the request wasn't authenticated with a signed token.
+func (app *application) contextGetClaims(r *http.Request) *jwt.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims
+}
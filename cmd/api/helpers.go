@@ -18,17 +18,22 @@ type envelope map[string]interface{}
 
 // readIDParam - gets the ID URL parameter from the current context
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// readNamedIDParam works like readIDParam(), for routes with more than one ID parameter
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	// When httprouter parses a request, interpolated parameters will be stored
 	// in the request context. Use ParamsFromContext() function to
 	// get the slice containing them.
 	params := httprouter.ParamsFromContext(r.Context())
 
-	// Use the ByName() method to get the value of the id parameter from the slice
+	// Use the ByName() method to get the value of the parameter from the slice
 	// ByName() always returns a string, se we will convert it to a base 10 int
 	// if the id is invalid return 404
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/data"
+	"github.com/ahojo/greenlight/internal/jwt"
+)
+
+// loadJWTKeyring loads the keys named by the -jwt-key flags. Each flag value is in the
+// format "kid:algorithm:path".
+func loadJWTKeyring(cfg config) (*jwt.Keyring, error) {
+	var keys []*jwt.Key
+
+	for _, spec := range cfg.auth.jwtKeys {
+		parts := strings.SplitN(spec, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid -jwt-key %q: must be in the format kid:algorithm:path", spec)
+		}
+
+		key, err := jwt.LoadKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return jwt.NewKeyring(cfg.auth.jwtActiveKID, keys...)
+}
+
+// jwtDenyList is an in-memory copy of the jwt_denylist table, so signed tokens can be
+// checked for early revocation without a database query on every request.
+type jwtDenyList struct {
+	mu       sync.RWMutex
+	tokens   map[string]time.Time // jti -> expiry
+	families map[string]time.Time // encoded token family -> expiry
+	users    map[int64]time.Time  // user ID -> tokens issued before this time are denied
+}
+
+func newJWTDenyList() *jwtDenyList {
+	return &jwtDenyList{
+		tokens:   make(map[string]time.Time),
+		families: make(map[string]time.Time),
+		users:    make(map[int64]time.Time),
+	}
+}
+
+// add records a single entry
+func (d *jwtDenyList) add(entry *data.DenyListEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry.JTI != "" {
+		d.tokens[entry.JTI] = entry.Expiry
+	}
+
+	if len(entry.Family) > 0 {
+		d.families[base64.RawURLEncoding.EncodeToString(entry.Family)] = entry.Expiry
+	}
+
+	if entry.UserID != 0 && entry.IssuedBefore.After(d.users[entry.UserID]) {
+		d.users[entry.UserID] = entry.IssuedBefore
+	}
+}
+
+// replace swaps the contents of the deny-list for the given entries
+func (d *jwtDenyList) replace(entries []*data.DenyListEntry) {
+	fresh := newJWTDenyList()
+	for _, entry := range entries {
+		fresh.add(entry)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tokens = fresh.tokens
+	d.families = fresh.families
+	d.users = fresh.users
+}
+
+// denied reports whether a token with the given claims has been revoked
+func (d *jwtDenyList) denied(claims *jwt.Claims, userID int64) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if _, found := d.tokens[claims.ID]; found {
+		return true
+	}
+
+	if _, found := d.families[claims.Family]; found && claims.Family != "" {
+		return true
+	}
+
+	issuedBefore, found := d.users[userID]
+	return found && claims.IssuedAt < issuedBefore.Unix()
+}
+
+// syncDenyList reloads the deny-list from the database. This is how entries added by
+// other instances of the API reach this one.
+func (app *application) syncDenyList() error {
+	entries, err := app.models.DenyList.GetAllActive()
+	if err != nil {
+		return err
+	}
+
+	app.denyList.replace(entries)
+	return nil
+}
+
+// startDenyListSync launches a background goroutine which keeps the deny-list in sync
+// with the database.
+func (app *application) startDenyListSync() {
+	go func() {
+		for {
+			time.Sleep(app.config.auth.denyListSync)
+
+			err := app.syncDenyList()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}()
+}
+
+// newJWT signs an authentication token for the user. The token carries everything the
+// authenticate middleware needs, so it can be verified without touching the database.
+func (app *application) newJWT(userID int64, family []byte) (*data.Token, error) {
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.tokens.authenticationTTL)
+
+	claims := jwt.Claims{
+		Subject:     strconv.FormatInt(userID, 10),
+		ID:          base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   expiry.Unix(),
+		Family:      base64.RawURLEncoding.EncodeToString(family),
+		Activated:   user.Activated,
+		Permissions: permissions,
+	}
+
+	plaintext, err := app.jwtKeys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{Plaintext: plaintext, UserID: userID, Expiry: expiry, Scope: data.ScopeAuthentication, Family: family}, nil
+}
+
+// authenticateJWT authenticates a request made with a signed token. Only the user's ID
+// and activation status are known, so handlers which need the full user record must be
+// wrapped with requireUserRecord().
+func (app *application) authenticateJWT(w http.ResponseWriter, r *http.Request, token string, next http.Handler) {
+	claims, err := app.jwtKeys.Verify(token)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || userID < 1 {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	if app.denyList.denied(claims, userID) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user := &data.User{ID: userID, Activated: claims.Activated}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetClaims(r, claims)
+	next.ServeHTTP(w, r)
+}
+
+// revokeJWT denies a single signed token, and deletes the refresh token issued with it
+func (app *application) revokeJWT(claims *jwt.Claims) error {
+	entry := &data.DenyListEntry{
+		JTI:    claims.ID,
+		Expiry: time.Unix(claims.ExpiresAt, 0),
+	}
+
+	err := app.models.DenyList.Insert(entry)
+	if err != nil {
+		return err
+	}
+	app.denyList.add(entry)
+
+	family, err := base64.RawURLEncoding.DecodeString(claims.Family)
+	if err != nil || len(family) == 0 {
+		return nil
+	}
+
+	err = app.models.Token.DeleteFamilyByID(family)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// revokeSessionJWTs denies every signed token issued in a session. It does nothing
+// unless we're issuing signed tokens.
+func (app *application) revokeSessionJWTs(family []byte) error {
+	if app.jwtKeys == nil {
+		return nil
+	}
+
+	entry := &data.DenyListEntry{
+		Family: family,
+		Expiry: time.Now().Add(app.config.tokens.authenticationTTL + time.Second),
+	}
+
+	err := app.models.DenyList.Insert(entry)
+	if err != nil {
+		return err
+	}
+	app.denyList.add(entry)
+
+	return nil
+}
+
+// revokeUserJWTs denies every signed token issued to the user so far. It does nothing
+// unless we're issuing signed tokens.
+func (app *application) revokeUserJWTs(userID int64) error {
+	if app.jwtKeys == nil {
+		return nil
+	}
+
+	// Tokens only record the second they were issued at, so round up to make sure
+	// any token issued during this second is denied too.
+	now := time.Now()
+
+	entry := &data.DenyListEntry{
+		UserID:       userID,
+		IssuedBefore: now.Truncate(time.Second).Add(time.Second),
+		Expiry:       now.Add(app.config.tokens.authenticationTTL + time.Second),
+	}
+
+	err := app.models.DenyList.Insert(entry)
+	if err != nil {
+		return err
+	}
+	app.denyList.add(entry)
+
+	return nil
+}
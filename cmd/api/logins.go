@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/data"
+)
+
+// accountLoginLimits and ipLoginLimits return the back-off settings for the two kinds of
+// login failure keys. IP addresses can be shared, so they allow more failures.
+func (app *application) accountLoginLimits() data.LoginLimits {
+	return data.LoginLimits{
+		LockAfter:   app.config.login.maxFailures,
+		BackoffBase: app.config.login.backoffBase,
+		Lockout:     app.config.login.lockout,
+	}
+}
+
+func (app *application) ipLoginLimits() data.LoginLimits {
+	return data.LoginLimits{
+		LockAfter:   app.config.login.ipMaxFailures,
+		BackoffBase: app.config.login.backoffBase,
+		Lockout:     app.config.login.lockout,
+	}
+}
+
+// startLogin counts a login attempt against the email address and the client's IP
+// address before the password is checked, and reports how long the client has to wait
+// when either of them is backing off. Otherwise it returns the email address's record,
+// which already counts this attempt as a failure. Call finishLogin() if the login
+// succeeds.
+func (app *application) startLogin(email, ip string) (*data.LoginFailure, time.Duration, error) {
+	ipKey := data.IPLoginKey(ip)
+	accountKey := data.AccountLoginKey(email)
+
+	_, err := app.models.Logins.Attempt(ipKey, app.ipLoginLimits())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLoginBackoff):
+			return nil, app.loginRetryAfter(ipKey), nil
+		default:
+			return nil, 0, err
+		}
+	}
+
+	account, err := app.models.Logins.Attempt(accountKey, app.accountLoginLimits())
+	if err != nil {
+		// The password won't be checked, so the attempt doesn't count against the IP address
+		releaseErr := app.models.Logins.Release(ipKey, app.config.login.ipMaxFailures)
+		if releaseErr != nil {
+			return nil, 0, releaseErr
+		}
+
+		switch {
+		case errors.Is(err, data.ErrLoginBackoff):
+			return nil, app.loginRetryAfter(accountKey), nil
+		default:
+			return nil, 0, err
+		}
+	}
+
+	return account, 0, nil
+}
+
+// finishLogin takes back the failures counted by startLogin() after a successful login.
+// The email address's failures are cleared. The IP address only loses this attempt, so
+// logging in to an account of their own doesn't reset the back-off for somebody guessing
+// other people's passwords.
+func (app *application) finishLogin(email, ip string) error {
+	err := app.models.Logins.Delete(data.AccountLoginKey(email))
+	if err != nil {
+		return err
+	}
+
+	return app.models.Logins.Release(data.IPLoginKey(ip), app.config.login.ipMaxFailures)
+}
+
+// loginRetryAfter returns how long the client has to wait before trying to log in with
+// a key which is backing off. It's only used for the Retry-After header, so it falls back
+// to the base delay if the key can't be read or has just stopped backing off.
+func (app *application) loginRetryAfter(key string) time.Duration {
+	wait := app.config.login.backoffBase
+
+	failure, err := app.models.Logins.Get(key)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.logger.PrintError(err, nil)
+		}
+		return wait
+	}
+
+	if d := app.loginBackoff(failure); d > wait {
+		wait = d
+	}
+
+	return wait
+}
+
+// loginBackoff returns how long is left before a key can be used to log in again.
+// After a lock it's the rest of the lock. Otherwise the wait after each failure doubles,
+// starting from the configured base delay. It must agree with the WHERE clause in
+// LoginFailureModel.Attempt().
+func (app *application) loginBackoff(failure *data.LoginFailure) time.Duration {
+	now := time.Now()
+
+	if failure.LockedUntil != nil && now.Before(*failure.LockedUntil) {
+		return failure.LockedUntil.Sub(now)
+	}
+
+	if failure.Failures < 1 {
+		return 0
+	}
+
+	delay := app.config.login.lockout
+	if failure.Failures < 32 {
+		if d := app.config.login.backoffBase << (failure.Failures - 1); d > 0 && d < delay {
+			delay = d
+		}
+	}
+
+	return failure.LastFailureAt.Add(delay).Sub(now)
+}
+
+// failedLoginResponse sends the client a 401 response for a failed login, which
+// startLogin() has already counted. When the failure locks the account, the owner is
+// sent an email about it. user is nil when there is no account with the email address.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, user *data.User, account *data.LoginFailure, ip string) {
+	cfg := app.config.login
+
+	// Only email the owner when the account first becomes locked
+	if user != nil && account.Failures == cfg.maxFailures {
+		app.background(func() {
+			data := map[string]interface{}{
+				"lockoutMinutes": int(cfg.lockout.Minutes()),
+				"ip":             ip,
+			}
+
+			err := app.mailer.Send(user.Email, "account_locked.gotmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	app.invalidCredentialResponse(w, r)
+}
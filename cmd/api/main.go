@@ -6,8 +6,10 @@ import (
 	"expvar"
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +17,7 @@ import (
 	// Import the pq driver. It will register itself with the db/sql pacakage.
 	"github.com/ahojo/greenlight/internal/data"
 	"github.com/ahojo/greenlight/internal/jsonlog"
+	"github.com/ahojo/greenlight/internal/jwt"
 
 	"github.com/ahojo/greenlight/internal/mailer"
 	_ "github.com/lib/pq"
@@ -58,6 +61,54 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	// activation struct to hold the per-email throttling settings for
+	// resending activation emails.
+	activation struct {
+		resendInterval time.Duration
+		resendBurst    int
+	}
+	// tokens struct to hold the lifetimes of authentication and refresh tokens
+	tokens struct {
+		authenticationTTL time.Duration
+		refreshTTL        time.Duration
+	}
+	// cache struct to hold the TTL for cached users and permissions
+	cache struct {
+		ttl time.Duration
+	}
+	// password struct to hold the algorithm and parameters for hashing new passwords
+	password struct {
+		algorithm         string
+		bcryptCost        int
+		argon2Memory      uint
+		argon2Iterations  uint
+		argon2Parallelism uint
+	}
+	// breachedPasswords struct to hold the location of the breached password corpus
+	breachedPasswords struct {
+		file              string
+		falsePositiveRate float64
+	}
+	// login struct to hold the brute-force protection settings for logging in
+	login struct {
+		maxFailures   int
+		ipMaxFailures int
+		backoffBase   time.Duration
+		lockout       time.Duration
+	}
+	// cleanup struct to hold the settings for purging expired tokens
+	cleanup struct {
+		interval  time.Duration
+		batchSize int
+	}
+	// auth struct to hold the authentication mode, and the signing keys used in
+	// "jwt" mode
+	auth struct {
+		mode         string
+		jwtKeys      []string
+		jwtActiveKID string
+		denyListSync time.Duration
+	}
 }
 
 // Holds the dependencies for our http handlers, helpers,
@@ -70,6 +121,18 @@ type application struct {
 	// Sync WaitGroup zero value = waitgroup with a value of 0
 	// Don't need to initialize it before use because it's zeroed out.
 	wg sync.WaitGroup
+	// Per-email limiter for the resend activation endpoint
+	activationLimiter *keyedLimiter
+	// Per-user limiter for TOTP code attempts
+	totpLimiter *keyedLimiter
+	// Signing keys and deny-list for signed authentication tokens. jwtKeys is nil
+	// unless the API is running in "jwt" mode.
+	jwtKeys  *jwt.Keyring
+	denyList *jwtDenyList
+	// shutdown is closed when the server starts a graceful shutdown, to stop
+	// long-running background tasks.
+	shutdown      chan struct{}
+	cleanupStatus *cleanupStatus
 }
 
 func main() {
@@ -108,6 +171,51 @@ func main() {
 		return nil
 	})
 
+	// Limit how often an activation email can be resent to the same address.
+	flag.DurationVar(&cfg.activation.resendInterval, "activation-resend-interval", 5*time.Minute, "Minimum interval between activation emails to the same address")
+	flag.IntVar(&cfg.activation.resendBurst, "activation-resend-burst", 1, "Activation emails allowed to the same address before throttling")
+
+	// Authentication tokens are short-lived. Clients use the refresh token to get new ones.
+	flag.DurationVar(&cfg.tokens.authenticationTTL, "token-authentication-ttl", 15*time.Minute, "Lifetime of authentication tokens")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+
+	// Cache the user and permissions looked up for each authenticated request.
+	// A TTL of 0 disables the cache.
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "How long to cache authenticated users and permissions (0 to disable)")
+
+	// Passwords are re-hashed with these settings when their owners next log in.
+	flag.StringVar(&cfg.password.algorithm, "password-algorithm", data.PasswordHashing.Algorithm, "Password hashing algorithm (argon2id|bcrypt)")
+	flag.IntVar(&cfg.password.bcryptCost, "password-bcrypt-cost", data.PasswordHashing.BcryptCost, "bcrypt cost")
+	flag.UintVar(&cfg.password.argon2Memory, "password-argon2-memory", uint(data.PasswordHashing.Argon2Memory), "argon2id memory in KiB")
+	flag.UintVar(&cfg.password.argon2Iterations, "password-argon2-iterations", uint(data.PasswordHashing.Argon2Iterations), "argon2id iterations")
+	flag.UintVar(&cfg.password.argon2Parallelism, "password-argon2-parallelism", uint(data.PasswordHashing.Argon2Parallelism), "argon2id parallelism")
+
+	// New passwords are checked against a local corpus of breached password hashes.
+	flag.StringVar(&cfg.breachedPasswords.file, "breached-passwords-file", "", "File of SHA-1 hashes of breached passwords, one per line (disabled if empty)")
+	flag.Float64Var(&cfg.breachedPasswords.falsePositiveRate, "breached-passwords-fp-rate", 0.001, "False positive rate of the breached password filter")
+
+	// Failed logins are counted per account and per IP address. Each failure doubles the
+	// wait before the next attempt, until enough failures lock logging in altogether.
+	flag.IntVar(&cfg.login.maxFailures, "login-max-failures", 5, "Failed logins before an account is locked")
+	flag.IntVar(&cfg.login.ipMaxFailures, "login-ip-max-failures", 20, "Failed logins before an IP address is locked")
+	flag.DurationVar(&cfg.login.backoffBase, "login-backoff-base", time.Second, "Wait after the first failed login, doubled after each further failure")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", 15*time.Minute, "How long logging in is locked for after too many failures")
+
+	// Expired tokens are purged in the background.
+	flag.DurationVar(&cfg.cleanup.interval, "cleanup-interval", 10*time.Minute, "How often to purge expired tokens (0 to disable)")
+	flag.IntVar(&cfg.cleanup.batchSize, "cleanup-batch-size", 1000, "Maximum rows deleted by each cleanup query")
+
+	// In "jwt" mode authentication tokens are signed, and verified without touching
+	// the database. Changes to a user's permissions only take effect when they next
+	// refresh their token.
+	flag.StringVar(&cfg.auth.mode, "auth-mode", "token", "Authentication token mode (token|jwt)")
+	flag.Func("jwt-key", "JWT key in the format kid:algorithm:path, where algorithm is HS256 or EdDSA (repeatable)", func(val string) error {
+		cfg.auth.jwtKeys = append(cfg.auth.jwtKeys, val)
+		return nil
+	})
+	flag.StringVar(&cfg.auth.jwtActiveKID, "jwt-active-kid", "", "ID of the JWT key used to sign new tokens")
+	flag.DurationVar(&cfg.auth.denyListSync, "jwt-denylist-sync", 30*time.Second, "How often to reload the JWT deny-list from the database")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -122,6 +230,39 @@ func main() {
 	// logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// The flags are plain uints, so catch values the argon2 parameters can't hold
+	// rather than letting the conversions below silently truncate them.
+	if uint64(cfg.password.argon2Memory) > math.MaxUint32 {
+		logger.PrintFatal(fmt.Errorf("invalid -password-argon2-memory %d: must be at most %d", cfg.password.argon2Memory, uint32(math.MaxUint32)), nil)
+	}
+	if uint64(cfg.password.argon2Iterations) > math.MaxUint32 {
+		logger.PrintFatal(fmt.Errorf("invalid -password-argon2-iterations %d: must be at most %d", cfg.password.argon2Iterations, uint32(math.MaxUint32)), nil)
+	}
+	if cfg.password.argon2Parallelism > math.MaxUint8 {
+		logger.PrintFatal(fmt.Errorf("invalid -password-argon2-parallelism %d: must be at most %d", cfg.password.argon2Parallelism, math.MaxUint8), nil)
+	}
+
+	data.PasswordHashing.Algorithm = cfg.password.algorithm
+	data.PasswordHashing.BcryptCost = cfg.password.bcryptCost
+	data.PasswordHashing.Argon2Memory = uint32(cfg.password.argon2Memory)
+	data.PasswordHashing.Argon2Iterations = uint32(cfg.password.argon2Iterations)
+	data.PasswordHashing.Argon2Parallelism = uint8(cfg.password.argon2Parallelism)
+
+	err := data.PasswordHashing.Validate()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if cfg.breachedPasswords.file != "" {
+		data.BreachedPasswords, err = data.LoadBreachedPasswords(cfg.breachedPasswords.file, cfg.breachedPasswords.falsePositiveRate)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("breached password corpus loaded", map[string]string{
+			"hashes": strconv.Itoa(data.BreachedPasswords.Len()),
+		})
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		// logger.Fatal(err)
@@ -141,6 +282,11 @@ func main() {
 	expvar.Publish("database", expvar.Func(func() interface{} {
 		return db.Stats()
 	}))
+	// Create the cache shared by our models, and publish its hit and miss counts.
+	cache := data.NewCache(cfg.cache.ttl)
+	expvar.Publish("cache", expvar.Func(func() interface{} {
+		return cache.Stats()
+	}))
 	// Publish the current Unix timestamp.
 	expvar.Publish("timestamp", expvar.Func(func() interface{} {
 		return time.Now().Unix()
@@ -150,8 +296,33 @@ func main() {
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: data.NewModels(db, cache),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		activationLimiter: newKeyedLimiter(cfg.activation.resendInterval, cfg.activation.resendBurst),
+		totpLimiter:       newKeyedLimiter(time.Minute, 5),
+		shutdown:          make(chan struct{}),
+		cleanupStatus:     &cleanupStatus{},
+	}
+
+	// Publish the outcome of the last expired token cleanup.
+	expvar.Publish("cleanup", expvar.Func(app.cleanupStatus.snapshot))
+
+	switch cfg.auth.mode {
+	case "token":
+	case "jwt":
+		app.jwtKeys, err = loadJWTKeyring(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		app.denyList = newJWTDenyList()
+		err = app.syncDenyList()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		app.startDenyListSync()
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid -auth-mode %q: must be token or jwt", cfg.auth.mode), nil)
 	}
 	// // Declare a HTTP server with some sensible timeout settings
 	// srv := &http.Server{
@@ -173,6 +344,13 @@ func main() {
 	// })
 	// err = srv.ListenAndServe()
 
+	if cfg.cleanup.interval > 0 {
+		if cfg.cleanup.batchSize < 1 {
+			logger.PrintFatal(fmt.Errorf("invalid -cleanup-batch-size %d: must be at least 1", cfg.cleanup.batchSize), nil)
+		}
+		app.startCleanup()
+	}
+
 	// Start the server now
 	err = app.serve()
 	if err != nil {
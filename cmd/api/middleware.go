@@ -161,18 +161,31 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// If there is an Auth header it should be in the format "Bearer <token>"
+		// If there is an Auth header it should be in the format "Bearer <token>", or
+		// "ApiKey <key>" for long-lived API keys.
 		// Try to split it into it's parts
 		// if not in the right format return a 401 to the user
 		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		if len(headerParts) != 2 || (headerParts[0] != "Bearer" && headerParts[0] != "ApiKey") {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
+		if headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, headerParts[1], next)
+			return
+		}
+
 		// Get the token
 		token := headerParts[1]
 
+		// Signed tokens are made of three dot separated parts. Opaque tokens never
+		// contain a dot, so any tokens issued before switching modes still work.
+		if app.jwtKeys != nil && strings.Count(token, ".") == 2 {
+			app.authenticateJWT(w, r, token, next)
+			return
+		}
+
 		// Validate tthe token to make sure it is in a sensible format
 		v := validator.New()
 
@@ -195,11 +208,40 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Call the contextSetUser() helper to add the user to the context
+		// Also store the token itself, so that it can be revoked on logout.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateAPIKey authenticates a request made with an "ApiKey <key>" Authorization
+// header. The key is stored in the request context alongside its owner, so that
+// requirePermission() can limit the request to the key's permissions.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, key); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	apiKey, user, err := app.models.APIKeys.GetForKey(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, apiKey)
+	next.ServeHTTP(w, r)
+}
+
 /*
 Notice here that our requireActivatedUser() middleware has a slightly different signature
 to the other middleware we’ve built in this book. Instead of accepting and returning a
@@ -267,19 +309,15 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 
 	fn := func(w http.ResponseWriter, r *http.Request) {
 
-		// Retrieve the user from the context
-		user := app.contextGetUser(r)
-
-		// Get the slice of permissions for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// Check if the user has the required permission
+		// if not it's a 403
+		permitted, err := app.hasPermission(r, code)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		// Check if the slice includes the required permissions
-		// if not it's a 401
-		if !permissions.Include(code) {
+		if !permitted {
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -293,6 +331,83 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// rejectAPIKeys refuses requests authenticated with an API key. It wraps the account and
+// token routes, which aren't guarded by a permission, so an API key can't be used to
+// manage its owner's account, however it's scoped. A leaked key could otherwise read the
+// owner's personal data, revoke their sessions or mint replacement keys.
+func (app *application) rejectAPIKeys(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireUserRecord makes sure the user in the request context is the full record from
+// the database. Requests authenticated with a signed token only carry the user's ID and
+// activation status, which isn't enough for handlers that show or change the user.
+func (app *application) requireUserRecord(next http.HandlerFunc) http.HandlerFunc {
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetClaims(r) == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			// The account was deleted after the token was issued
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// hasPermission reports whether the user making the request has a permission. Handlers
+// use it directly when a permission only matters in some cases, such as moderators
+// being allowed to change other users' reviews.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+
+	// Retrieve the user from the context
+	user := app.contextGetUser(r)
+
+	// Get the slice of permissions for the user. Signed tokens carry their own.
+	var permissions data.Permissions
+	if claims := app.contextGetClaims(r); claims != nil {
+		permissions = claims.Permissions
+	} else {
+		var err error
+		permissions, err = app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if !permissions.Include(code) {
+		return false, nil
+	}
+
+	// Requests made with an API key are also limited to the key's own permissions
+	if apiKey := app.contextGetAPIKey(r); apiKey != nil && !apiKey.Permissions.Include(code) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
 func (app *application) enableCors(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
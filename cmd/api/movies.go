@@ -73,8 +73,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	// input struct to hold expected values
 	var input struct {
-		Title   string
-		Genres  []string
+		data.MovieFilters
 		Filters 	data.Filters
 	}
 
@@ -89,6 +88,23 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// genres_mode=all (the default) lists movies with every genre in genres, and
+	// genres_mode=any lists movies with at least one of them. Movies with any of the
+	// exclude_genres are left out either way.
+	input.GenresMode = app.readString(qs, "genres_mode", data.GenresModeAll)
+	input.ExcludeGenres = app.readCSV(qs, "exclude_genres", []string{})
+
+	// Only list the movies a person is credited on. 0 means any person.
+	input.PersonID = int64(app.readInt(qs, "person_id", 0, v))
+
+	// Year and runtime ranges. 0 means the range is open at that end.
+	input.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.RuntimeMin = app.readInt(qs, "runtime_min", 0, v)
+	input.RuntimeMax = app.readInt(qs, "runtime_max", 0, v)
+
+	data.ValidateMovieFilters(v, input.MovieFilters)
+
 	// Get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
 	// validator instance as the final argument here
@@ -117,7 +133,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Retrieve the movies, passing in the Filters when needed
-	movies, metadata ,err := app.models.Movies.GetAll(input.Title, input.Genres,input.Filters)
+	movies, metadata ,err := app.models.Movies.GetAll(input.MovieFilters, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w,r,err)
 		return
@@ -139,6 +155,19 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// ?include=credits adds the movie's cast and crew to the response
+	include := app.readCSV(r.URL.Query(), "include", []string{})
+
+	v := validator.New()
+	for _, value := range include {
+		v.Check(validator.In(value, "credits"), "include", "must only contain credits")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client.
@@ -154,7 +183,19 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	response := envelope{"movie": movie}
+
+	if validator.In("credits", include...) {
+		credits, err := app.models.People.GetCreditsForMovie(movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		response["credits"] = credits
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
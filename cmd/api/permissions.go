@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ahojo/greenlight/internal/data"
+	"github.com/ahojo/greenlight/internal/validator"
+)
+
+// listPermissionsHandler for "GET /v1/permissions"
+// Lists every permission code that can be granted to a user.
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserPermissionsHandler for "GET /v1/admin/users/:id/permissions"
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// addUserPermissionsHandler for "POST /v1/admin/users/:id/permissions"
+// Grants permission codes to a user. Granting a code the user already has is not an error.
+func (app *application) addUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Permissions.AddForUser(user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// removeUserPermissionsHandler for "DELETE /v1/admin/users/:id/permissions"
+// Revokes permission codes from a user. Revoking a code the user doesn't have is not an error.
+func (app *application) removeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Permissions.RemoveForUser(user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// readUserParam looks up the user identified by the :id URL parameter. If the user
+// can't be found, or something goes wrong, it sends the response itself and returns false.
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// readPermissionCodes reads a {"codes": [...]} request body and checks that every
+// code exists. If the body is invalid it sends the response itself and returns false.
+func (app *application) readPermissionCodes(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Codes) >= 1, "codes", "must contain at least 1 permission code")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+
+	for _, code := range input.Codes {
+		v.Check(validator.In(code, known...), "codes", "must only contain known permission codes")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return input.Codes, true
+}
+
+// writeUserPermissions sends the user's current permission codes to the client
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *data.User) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user_id": user.ID, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ahojo/greenlight/internal/data"
+	"github.com/ahojo/greenlight/internal/validator"
+)
+
+// listRolesHandler for "GET /v1/roles"
+// Lists every role along with the permission codes it bundles.
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createRoleHandler for "POST /v1/roles"
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	data.ValidateRole(v, role)
+	for _, code := range role.Permissions {
+		v.Check(validator.In(code, known...), "permissions", "must only contain known permission codes")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Insert(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/roles/%d", role.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteRoleHandler for "DELETE /v1/roles/:id"
+func (app *application) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Roles.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addRolePermissionsHandler for "POST /v1/roles/:id/permissions"
+func (app *application) addRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.readRoleParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.AddPermissions(role.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeRole(w, r, role.ID)
+}
+
+// removeRolePermissionsHandler for "DELETE /v1/roles/:id/permissions"
+func (app *application) removeRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.readRoleParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.RemovePermissions(role.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeRole(w, r, role.ID)
+}
+
+// showUserRolesHandler for "GET /v1/admin/users/:id/roles"
+func (app *application) showUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserRoles(w, r, user)
+}
+
+// addUserRolesHandler for "POST /v1/admin/users/:id/roles"
+// Assigns roles to a user. Assigning a role the user already has is not an error.
+func (app *application) addUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	names, ok := app.readRoleNames(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.AddForUser(user.ID, names...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserRoles(w, r, user)
+}
+
+// removeUserRolesHandler for "DELETE /v1/admin/users/:id/roles"
+// Unassigns roles from a user. Unassigning a role the user doesn't have is not an error.
+func (app *application) removeUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	names, ok := app.readRoleNames(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.RemoveForUser(user.ID, names...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserRoles(w, r, user)
+}
+
+// readRoleParam looks up the role identified by the :id URL parameter. If the role
+// can't be found, or something goes wrong, it sends the response itself and returns false.
+func (app *application) readRoleParam(w http.ResponseWriter, r *http.Request) (*data.Role, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	role, err := app.models.Roles.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return role, true
+}
+
+// readRoleNames reads a {"roles": [...]} request body and checks that every role
+// exists. If the body is invalid it sends the response itself and returns false.
+func (app *application) readRoleNames(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	known := make([]string, len(roles))
+	for i := range roles {
+		known[i] = roles[i].Name
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Roles) >= 1, "roles", "must contain at least 1 role")
+	v.Check(validator.Unique(input.Roles), "roles", "must not contain duplicate values")
+
+	for _, name := range input.Roles {
+		v.Check(validator.In(name, known...), "roles", "must only contain known roles")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return input.Roles, true
+}
+
+// writeRole sends the current state of a role to the client
+func (app *application) writeRole(w http.ResponseWriter, r *http.Request, id int64) {
+	role, err := app.models.Roles.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writeUserRoles sends the user's roles, and the permissions they add up to, to the client
+func (app *application) writeUserRoles(w http.ResponseWriter, r *http.Request, user *data.User) {
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user_id": user.ID, "roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
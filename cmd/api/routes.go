@@ -35,12 +35,81 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write",app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write",app.deleteMovieHandler))
 
+	// Writing reviews needs reviews:write, which users get by default. Changing somebody
+	// else's review also needs the reviews:moderate permission, which the handlers check.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("reviews:write", app.createMovieReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.showMovieReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requirePermission("reviews:write", app.updateMovieReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requirePermission("reviews:write", app.deleteMovieReviewHandler))
+
+	// Cast and crew. Anyone who can read movies can read their credits, but managing
+	// people is part of curating the catalogue.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.createMovieCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission("movies:write", app.deleteMovieCreditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:write", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:write", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+
 	// Route to create our user
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	// The account and token routes below aren't guarded by a permission, so they reject
+	// API keys outright. Only the user's own credentials can manage their account.
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.rejectAPIKeys(app.registerUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.rejectAPIKeys(app.activateUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.rejectAPIKeys(app.updateUserPasswordHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.rejectAPIKeys(app.requireUserRecord(app.showCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.rejectAPIKeys(app.requireUserRecord(app.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.rejectAPIKeys(app.requireUserRecord(app.deleteCurrentUserHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.rejectAPIKeys(app.requireUserRecord(app.exportCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.rejectAPIKeys(app.requireActivatedUser(app.requireUserRecord(app.createEmailChangeHandler))))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.rejectAPIKeys(app.requireActivatedUser(app.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.rejectAPIKeys(app.requireActivatedUser(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.rejectAPIKeys(app.requireActivatedUser(app.deleteAPIKeyHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.rejectAPIKeys(app.requireActivatedUser(app.requireUserRecord(app.createTOTPHandler))))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/totp", app.rejectAPIKeys(app.requireActivatedUser(app.enableTOTPHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.rejectAPIKeys(app.requireActivatedUser(app.requireUserRecord(app.deleteTOTPHandler))))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.rejectAPIKeys(app.requireActivatedUser(app.listWatchlistHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", app.rejectAPIKeys(app.requireActivatedUser(app.addWatchlistHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist", app.rejectAPIKeys(app.requireActivatedUser(app.deleteWatchlistHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.rejectAPIKeys(app.requireAuthenticatedUser(app.listSessionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.rejectAPIKeys(app.requireAuthenticatedUser(app.deleteSessionHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.rejectAPIKeys(app.confirmEmailChangeHandler))
+
+	// Admin user-management routes. These live under /v1/admin because httprouter
+	// doesn't allow a /v1/users/:id wildcard alongside /v1/users/me and friends.
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission("users:admin", app.updateUserActivationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/password-reset", app.requirePermission("users:admin", app.forcePasswordResetHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:admin", app.deleteUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.showUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.addUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.removeUserPermissionsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.showUserRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.addUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.removeUserRolesHandler))
+
+	// Permission and role routes
+	router.HandlerFunc(http.MethodGet, "/v1/permissions", app.requirePermission("users:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/roles", app.requirePermission("users:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/roles", app.requirePermission("users:admin", app.createRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/roles/:id", app.requirePermission("users:admin", app.deleteRoleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/roles/:id/permissions", app.requirePermission("users:admin", app.addRolePermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/roles/:id/permissions", app.requirePermission("users:admin", app.removeRolePermissionsHandler))
 
 	// Token route
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.rejectAPIKeys(app.createAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.rejectAPIKeys(app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.rejectAPIKeys(app.requireAuthenticatedUser(app.deleteAllAuthenticationTokensHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.rejectAPIKeys(app.refreshAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/totp", app.rejectAPIKeys(app.createTOTPTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.rejectAPIKeys(app.createPasswordResetTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.rejectAPIKeys(app.createActivationTokenHandler))
 
 	// Register a new GET enpoint that will display data
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
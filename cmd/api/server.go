@@ -74,6 +74,9 @@ func (app *application) serve() error {
 		  shutdownError <- err
 		}
 
+		// Signal long-running background tasks to stop
+		close(app.shutdown)
+
 		// Tell the logger that we are waiting for background tasks to complete
 		app.logger.PrintInfo("Completing background tasks", map[string]string{
 			"addr": srv.Addr,
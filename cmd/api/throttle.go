@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// keyedLimiter works like the rateLimit() middleware, but rate limits on an arbitrary
+// key (such as an email address) instead of the client's IP address.
+type keyedLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	clients map[string]*keyedClient
+}
+
+type keyedClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newKeyedLimiter returns a keyedLimiter which allows burst events per key, refilled
+// at a rate of one event every interval.
+func newKeyedLimiter(interval time.Duration, burst int) *keyedLimiter {
+	l := &keyedLimiter{
+		limit:   rate.Every(interval),
+		burst:   burst,
+		clients: make(map[string]*keyedClient),
+	}
+
+	// Launch a background goroutine which removes entries which haven't been seen
+	// for long enough that their limiter would be full again anyway.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			l.mu.Lock()
+			for key, client := range l.clients {
+				if time.Since(client.lastSeen) > interval*time.Duration(burst) {
+					delete(l.clients, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+// allow reports whether an event for the key may happen now. Keys are compared
+// case-insensitively so "Alice@example.com" and "alice@example.com" share a limiter.
+func (l *keyedLimiter) allow(key string) bool {
+	key = strings.ToLower(key)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, found := l.clients[key]; !found {
+		l.clients[key] = &keyedClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+	}
+	l.clients[key].lastSeen = time.Now()
+
+	return l.clients[key].limiter.Allow()
+}
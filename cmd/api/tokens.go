@@ -3,10 +3,12 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ahojo/greenlight/internal/data"
 	"github.com/ahojo/greenlight/internal/validator"
+	"github.com/tomasen/realip"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -34,6 +36,22 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Refuse to check the password while the account or the client's IP address is
+	// backing off after failed logins. Otherwise the attempt is counted as a failure
+	// until the password turns out to be right.
+	ip := realip.FromRequest(r)
+
+	account, retryAfter, err := app.startLogin(input.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		app.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+
 	// Lookup the user record based on the email address.
 	// If no matching user found, send an invalid respose
 	// to send a 401 unauthorized
@@ -42,7 +60,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialResponse(w, r)
+			app.failedLoginResponse(w, r, nil, account, ip)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -57,13 +75,45 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
-		app.invalidCredentialResponse(w, r)
+		app.failedLoginResponse(w, r, user, account, ip)
+		return
+	}
+
+	err = app.finishLogin(input.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// Move the password over to the current hashing settings while we know the plaintext
+	app.rehashPassword(user, input.Password)
 
-	// If the passwords match, generate a new 24 hour token
-	token, err := app.models.Token.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+
+	// Users with two-factor authentication get a short-lived challenge token instead,
+	// which they exchange together with a code at POST /v1/tokens/totp.
+	totpEnabled, err := app.models.TOTP.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if totpEnabled {
+		challenge, err := app.models.Token.New(user.ID, 5*time.Minute, data.ScopeTOTPChallenge)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"totp_challenge_token": challenge}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the passwords match, start a new session with a short-lived authentication
+	// token and a refresh token which can be exchanged for new ones.
+	tokens, err := app.newSession(r, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w,r,err)
 		return
@@ -72,8 +122,384 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// Sometimes the token is sent in an Authorization header, but this is a violation of the HTTP specification
 	// Authorization is a request header and not a response one. 
 	// Encode the token to JSON and send it in the response along with a 201 created. 
-	err = app.writeJSON(w,http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w,http.StatusCreated, tokens, nil)
 	if err != nil {
 		app.serverErrorResponse(w,r,err)
 	}
 }
+
+// refreshAuthenticationTokenHandler for "POST /v1/tokens/refresh"
+// Rotates a refresh token: the token is used up, and a new authentication token and
+// refresh token are issued in the same family. Presenting a refresh token which has
+// already been rotated revokes the whole family.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Token.Rotate(input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			// Either the client or an attacker is replaying a stolen copy of the token.
+			// We can't tell which, so log everybody using this family out.
+			app.logger.PrintInfo("refresh token reuse detected, revoking token family", map[string]string{
+				"user_id": strconv.FormatInt(token.UserID, 10),
+			})
+
+			err = app.models.Token.DeleteFamily(data.ScopeRefresh, input.RefreshToken)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			err = app.revokeSessionJWTs(token.Family)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Sessions.Touch(token.Family, r.UserAgent(), realip.FromRequest(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tokens, err := app.newAuthenticationTokens(token.UserID, token.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, tokens, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// rehashPassword re-hashes the user's password if it was hashed with outdated settings.
+// Failing to do so doesn't stop the user logging in, so errors are only logged.
+func (app *application) rehashPassword(user *data.User, plaintext string) {
+	rehash, err := user.Password.NeedsRehash()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	if !rehash {
+		return
+	}
+
+	err = user.Password.Set(plaintext)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	// An edit conflict means the user was changed by another request, which is fine.
+	// The password will be re-hashed at the next login instead.
+	err = app.models.Users.Update(user)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// newSession records a new session for the user, with details of the client which
+// started it, and issues the session's first authentication and refresh tokens.
+func (app *application) newSession(r *http.Request, userID int64) (envelope, error) {
+	family, err := data.NewTokenFamily()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &data.Session{
+		UserID:    userID,
+		Family:    family,
+		UserAgent: r.UserAgent(),
+		IP:        realip.FromRequest(r),
+	}
+
+	err = app.models.Sessions.Insert(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.newAuthenticationTokens(userID, family)
+}
+
+// newAuthenticationTokens creates an authentication token and a refresh token for the
+// user in the given token family, and returns them ready to be sent to the client.
+// In "jwt" mode the authentication token is signed rather than stored.
+func (app *application) newAuthenticationTokens(userID int64, family []byte) (envelope, error) {
+	var authenticationToken *data.Token
+	var err error
+
+	if app.jwtKeys != nil {
+		authenticationToken, err = app.newJWT(userID, family)
+	} else {
+		authenticationToken, err = app.models.Token.NewInFamily(userID, app.config.tokens.authenticationTTL, data.ScopeAuthentication, family)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := app.models.Token.NewInFamily(userID, app.config.tokens.refreshTTL, data.ScopeRefresh, family)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{"authentication_token": authenticationToken, "refresh_token": refreshToken}, nil
+}
+
+// revokeAuthenticationTokens logs a user out everywhere, by deleting all of their
+// authentication and refresh tokens, and denying any signed tokens.
+func (app *application) revokeAuthenticationTokens(userID int64) error {
+	err := app.revokeUserJWTs(userID)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Token.DeleteAllForUser(data.ScopeAuthentication, userID)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Token.DeleteAllForUser(data.ScopeRefresh, userID)
+}
+
+// createPasswordResetTokenHandler for "POST /v1/tokens/password-reset"
+// Generates a one-time password reset token and emails it to the user.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Parse and validate the user's email address
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Try to retrieve the user record for the email address.
+	// If it can't be found, return an error message to the client.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Return an error message if the user is not activated
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Otherwise, create a new password reset token with a 45-minute expiry time
+	token, err := app.models.Token.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Email the user with their password reset token
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err = app.mailer.Send(user.Email, "token_password_reset.gotmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	// Send a 202 Accepted response and confirmation message to the client
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler for "POST /v1/tokens/activation"
+// Sends a fresh activation token to a user whose welcome email was lost.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Parse and validate the user's email address
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Throttle on the email address, so this endpoint can't be used to flood
+	// somebody's inbox even when the requests come from many different IPs.
+	if !app.activationLimiter.allow(input.Email) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// Try to retrieve the user record for the email address
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Return an error if the user has already been activated
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Remove any existing activation tokens, so only the newest one can be used
+	err = app.models.Token.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Otherwise, create a new activation token
+	token, err := app.models.Token.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Email the user with their additional activation token
+	app.background(func() {
+		data := map[string]interface{}{
+			"activationToken": token.Plaintext,
+		}
+
+		// Since email addresses MAY be case sensitive, notice that we are sending this
+		// email using the address stored in our database for the user --- not to the
+		// input.Email address provided by the client in this request.
+		err = app.mailer.Send(user.Email, "token_activation.gotmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	// Send a 202 Accepted response and confirmation message to the client
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler for "DELETE /v1/tokens/authentication"
+// Logs the user out by revoking the token that was used to make this request, along
+// with the refresh token that was issued with it.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Signed tokens can't be deleted, so they're added to the deny-list instead
+	if claims := app.contextGetClaims(r); claims != nil {
+		err := app.revokeJWT(claims)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The authenticate middleware stores the token it verified in the request context
+	token := app.contextGetToken(r)
+
+	err := app.models.Token.DeleteFamily(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		// The token may have been revoked by a concurrent request
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllAuthenticationTokensHandler for "DELETE /v1/tokens/authentication/all"
+// Logs the user out everywhere by revoking all of their authentication tokens.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeAuthenticationTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
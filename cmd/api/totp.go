@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/data"
+	"github.com/ahojo/greenlight/internal/totp"
+	"github.com/ahojo/greenlight/internal/validator"
+)
+
+// The issuer name shown next to the account in authenticator apps
+const totpIssuer = "Greenlight"
+
+// createTOTPHandler for "POST /v1/users/me/totp"
+// Starts TOTP enrolment by generating a new secret. TOTP isn't enabled until the user
+// confirms the secret with a code from their authenticator app.
+func (app *application) createTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePassword(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Start(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPAlreadyEnabled):
+			v.AddError("totp", "is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"totp": envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enableTOTPHandler for "PUT /v1/users/me/totp"
+// Confirms TOTP enrolment with a code, and returns a set of single-use recovery codes.
+func (app *application) enableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	settings, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "enrolment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if settings.Enabled {
+		v.AddError("totp", "is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	counter, ok := totp.Validate(input.Code, settings.Secret, time.Now())
+	if ok {
+		ok, err = app.models.TOTP.UseCounter(user.ID, counter)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !ok {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := app.models.TOTP.Enable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTOTPHandler for "DELETE /v1/users/me/totp"
+// Turns TOTP off. Both the password and a current code (or recovery code) are needed.
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePassword(v, input.Password)
+	data.ValidateTOTPCode(v, input.Code)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	settings, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := app.verifyTOTPCode(settings, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTOTPTokenHandler for "POST /v1/tokens/totp"
+// Exchanges the challenge token issued by createAuthenticationTokenHandler, together
+// with a TOTP code or recovery code, for an authentication token.
+func (app *application) createTOTPTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.ChallengeToken)
+	data.ValidateTOTPCode(v, input.Code)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTOTPChallenge, input.ChallengeToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// There are only a million codes, so limit how many can be tried for an account
+	if !app.totpLimiter.allow(strconv.FormatInt(user.ID, 10)) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	settings, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		// TOTP was turned off after the challenge was issued
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := app.verifyTOTPCode(settings, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	// The challenge token is single use
+	err = app.models.Token.DeleteAllForUser(data.ScopeTOTPChallenge, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tokens, err := app.newSession(r, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, tokens, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyTOTPCode checks a TOTP code or a recovery code for an enabled TOTP. Both kinds
+// of code are used up by a successful check, so neither can be replayed.
+func (app *application) verifyTOTPCode(settings *data.TOTP, code string) (bool, error) {
+	if !settings.Enabled {
+		return false, nil
+	}
+
+	if len(code) != totp.Digits {
+		return app.models.TOTP.UseRecoveryCode(settings.UserID, code)
+	}
+
+	counter, ok := totp.Validate(code, settings.Secret, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return app.models.TOTP.UseCounter(settings.UserID, counter)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/data"
+	"github.com/ahojo/greenlight/internal/validator"
+)
+
+// listWatchlistHandler for "GET /v1/users/me/watchlist"
+// The optional status query string parameter limits the list to to-watch or watched movies.
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status  string
+		Filters data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Most recently added first by default
+	input.Filters.Sort = app.readString(qs, "sort", "-added_at")
+	input.Filters.SortSafelist = []string{"added_at", "watched_at", "title", "-added_at", "-watched_at", "-title"}
+
+	if input.Status != "" {
+		v.Check(validator.In(input.Status, data.WatchlistToWatch, data.WatchlistWatched), "status", "must be to-watch or watched")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	entries, metadata, err := app.models.Watchlist.GetAllForUser(user.ID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlist": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addWatchlistHandler for "POST /v1/users/me/watchlist"
+// Adding a movie which is already on the watchlist updates its status instead.
+func (app *application) addWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID   int64      `json:"movie_id"`
+		Status    string     `json:"status"`
+		WatchedAt *time.Time `json:"watched_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Status == "" {
+		input.Status = data.WatchlistToWatch
+	}
+
+	v := validator.New()
+
+	entry := &data.WatchlistEntry{
+		Movie:     &data.Movie{ID: input.MovieID},
+		Status:    input.Status,
+		WatchedAt: input.WatchedAt,
+	}
+
+	if data.ValidateWatchlistEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Default the watched date to now when it isn't given
+	if entry.Status == data.WatchlistWatched && entry.WatchedAt == nil {
+		now := time.Now()
+		entry.WatchedAt = &now
+	}
+
+	movie, err := app.models.Movies.Get(input.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "no matching movie found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	entry.UserID = user.ID
+	entry.Movie = movie
+
+	err = app.models.Watchlist.Upsert(entry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlist_entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWatchlistHandler for "DELETE /v1/users/me/watchlist"
+// The movie to remove is given in the request body.
+func (app *application) deleteWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID int64 `json:"movie_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.MovieID > 0, "movie_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Watchlist.Delete(user.ID, input.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
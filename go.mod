@@ -3,11 +3,14 @@ module github.com/ahojo/greenlight
 go 1.16
 
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
+	github.com/go-mail/mail/v2 v2.3.0
 	github.com/julienschmidt/httprouter v1.3.0
-	github.com/lib/pq v1.10.0 // indirect
-	github.com/tomasen/realip v0.0.0-20180522021738-f0c99a92ddce // indirect
-	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5 // indirect
-	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac // indirect
+	github.com/lib/pq v1.10.0
+	github.com/tomasen/realip v0.0.0-20180522021738-f0c99a92ddce
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	// x/crypto/argon2 and x/crypto/blake2b import x/sys/cpu. x/crypto only asks for
+	// an untagged 2021 x/sys commit, so pin the tagged release we build and test with.
+	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 )
@@ -0,0 +1,76 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Filter is a Bloom filter over keys which are already uniformly distributed hashes,
+// such as SHA-1 digests. Keys must be at least 16 bytes long. A Filter can report false
+// positives, at roughly the rate it was sized for, but never false negatives.
+type Filter struct {
+	bits   []uint64
+	m      uint64 // number of bits
+	k      uint64 // number of bit positions per key
+	length int
+}
+
+// New returns a Filter sized to hold n keys with the given false positive rate
+func New(n int, falsePositiveRate float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+
+	// The standard formulas for the optimal number of bits and hash functions
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+
+	if k < 1 {
+		k = 1
+	}
+
+	words := (uint64(m) + 63) / 64
+
+	return &Filter{
+		bits: make([]uint64, words),
+		m:    words * 64,
+		k:    uint64(k),
+	}
+}
+
+// Add adds a key to the filter
+func (f *Filter) Add(key []byte) {
+	h1, h2 := split(key)
+
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+
+	f.length++
+}
+
+// Test reports whether a key might have been added to the filter
+func (f *Filter) Test(key []byte) bool {
+	h1, h2 := split(key)
+
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Len returns the number of keys added to the filter
+func (f *Filter) Len() int {
+	return f.length
+}
+
+// split derives the two hashes used for double hashing from the key itself. The
+// second hash is made odd, so it can't be a multiple of the (even) number of bits.
+func split(key []byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(key[0:8]), binary.BigEndian.Uint64(key[8:16]) | 1
+}
@@ -0,0 +1,75 @@
+package bloom
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"testing"
+)
+
+// key returns the SHA-1 digest of a string, the kind of key the filter is built for
+func key(s string) []byte {
+	sum := sha1.Sum([]byte(s))
+	return sum[:]
+}
+
+func TestNoFalseNegatives(t *testing.T) {
+	const n = 10_000
+
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(key(fmt.Sprintf("member-%d", i)))
+	}
+
+	if f.Len() != n {
+		t.Errorf("Len() = %d; want %d", f.Len(), n)
+	}
+
+	for i := 0; i < n; i++ {
+		if k := fmt.Sprintf("member-%d", i); !f.Test(key(k)) {
+			t.Fatalf("Test(%q) = false for an added key", k)
+		}
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	const (
+		n      = 10_000
+		trials = 200_000
+	)
+
+	for _, rate := range []float64{0.01, 0.001} {
+		t.Run(fmt.Sprint(rate), func(t *testing.T) {
+			f := New(n, rate)
+			for i := 0; i < n; i++ {
+				f.Add(key(fmt.Sprintf("member-%d", i)))
+			}
+
+			falsePositives := 0
+			for i := 0; i < trials; i++ {
+				if f.Test(key(fmt.Sprintf("non-member-%d", i))) {
+					falsePositives++
+				}
+			}
+
+			// The keys are fixed, so this isn't flaky. The bounds leave room for the
+			// filter being rounded up to whole words.
+			measured := float64(falsePositives) / trials
+			if measured > rate*1.5 || measured < rate/3 {
+				t.Errorf("false positive rate = %.5f; want about %.5f", measured, rate)
+			}
+		})
+	}
+}
+
+func TestEmptyFilter(t *testing.T) {
+	f := New(0, 0.01)
+
+	if f.Test(key("anything")) {
+		t.Error("Test() = true on an empty filter")
+	}
+
+	f.Add(key("anything"))
+	if !f.Test(key("anything")) {
+		t.Error("Test() = false for an added key")
+	}
+}
@@ -0,0 +1,243 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateAPIKeyName = errors.New("duplicate api key name")
+)
+
+// APIKey is a long-lived, named credential for service-to-service access. Like a
+// Token, only the SHA-256 hash of the key is stored. A key only carries the
+// permission codes it was created with, and only while its owner still has them.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"` // Only set when the key is created
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry,omitempty"` // nil means the key never expires
+	LastUsedAt  *time.Time  `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// generateAPIKey creates a key from 32 random bytes. Encoded as base-32 without
+// padding this gives a 52 character plaintext.
+func generateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key, nil
+}
+
+// Check that the plaintext API key has been provided and is exactly 52 bytes long
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(keyPlaintext != "", "key", "must be provided")
+	v.Check(len(keyPlaintext) == 52, "key", "must be 52 bytes long")
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission code")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// New() shortcut to create a new APIKey struct and insert the data into the database
+func (m APIKeyModel) New(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(key)
+	return key, err
+}
+
+// Insert adds an API key to the api_keys table
+func (m APIKeyModel) Insert(key *APIKey) error {
+	query := `
+	INSERT INTO api_keys (user_id, name, hash, permissions, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	args := []interface{}{key.UserID, key.Name, key.Hash, pq.Array(key.Permissions), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "api_keys_user_id_name_key"`:
+			return ErrDuplicateAPIKeyName
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAllForUser returns every API key belonging to a user, including expired ones
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+	SELECT id, user_id, name, permissions, expiry, last_used_at, created_at
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			pq.Array(&key.Permissions),
+			&key.Expiry,
+			&key.LastUsedAt,
+			&key.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetForKey looks up an unexpired API key and its owner from the plaintext key, and
+// records that the key has just been used.
+func (m APIKeyModel) GetForKey(keyPlaintext string) (*APIKey, *User, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	// The UPDATE ... RETURNING in the CTE sets last_used_at and gives us the key in
+	// the same round trip as the user lookup.
+	query := `
+	WITH key AS (
+		UPDATE api_keys SET last_used_at = $2
+		WHERE hash = $1 AND (expiry IS NULL OR expiry > $2)
+		RETURNING id, user_id, name, permissions, expiry, last_used_at, created_at
+	)
+	SELECT key.id, key.name, key.permissions, key.expiry, key.last_used_at, key.created_at,
+		users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, COALESCE(users.pending_email, ''), users.version
+	FROM key
+	INNER JOIN users ON users.id = key.user_id`
+
+	var key APIKey
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:], time.Now()).Scan(
+		&key.ID,
+		&key.Name,
+		pq.Array(&key.Permissions),
+		&key.Expiry,
+		&key.LastUsedAt,
+		&key.CreatedAt,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.PendingEmail,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	key.UserID = user.ID
+	return &key, &user, nil
+}
+
+// Delete revokes one of a user's API keys
+func (m APIKeyModel) Delete(id int64, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM api_keys
+	WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ahojo/greenlight/internal/bloom"
+	"github.com/ahojo/greenlight/internal/validator"
+)
+
+// BreachedPasswords holds the SHA-1 hashes of passwords known to have been leaked in
+// data breaches. It's nil, and no passwords are rejected, unless a corpus is loaded.
+var BreachedPasswords *bloom.Filter
+
+// LoadBreachedPasswords reads a corpus of breached password hashes into a Bloom filter.
+// The file has one hex encoded SHA-1 hash per line. Anything after a colon is ignored,
+// so files in the "HASH:COUNT" format used by Have I Been Pwned work as they are.
+func LoadBreachedPasswords(path string, falsePositiveRate float64) (*bloom.Filter, error) {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, fmt.Errorf("breached passwords false positive rate must be between 0 and 1")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Count the hashes first so the filter can be sized without holding the whole
+	// corpus in memory.
+	n := 0
+	err = scanBreachedPasswords(file, func([]byte) { n++ })
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bloom.New(n, falsePositiveRate)
+
+	err = scanBreachedPasswords(file, filter.Add)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func scanBreachedPasswords(r io.Reader, fn func(hash []byte)) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		// Skip blank lines, but not a line which is only a count
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+
+		hash, err := hex.DecodeString(text)
+		if err != nil || len(hash) != sha1.Size {
+			return fmt.Errorf("breached passwords line %d: not a SHA-1 hash", line)
+		}
+
+		fn(hash)
+	}
+
+	return scanner.Err()
+}
+
+// IsBreachedPassword reports whether a password appears in the breached password corpus
+func IsBreachedPassword(password string) bool {
+	if BreachedPasswords == nil {
+		return false
+	}
+
+	hash := sha1.Sum([]byte(password))
+	return BreachedPasswords.Test(hash[:])
+}
+
+// ValidateNewPassword checks a password which is about to be set. On top of the usual
+// checks, the password mustn't be one that's known to have been leaked.
+func ValidateNewPassword(v *validator.Validator, password string) {
+	ValidatePassword(v, password)
+
+	v.Check(!IsBreachedPassword(password), "password", "has appeared in a data breach and can't be used, please choose a different password")
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ahojo/greenlight/internal/validator"
+)
+
+// writeCorpus writes a breached passwords file and returns its path
+func writeCorpus(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	corpus := strings.Join([]string{
+		sha1Hex("password123"),
+		"",
+		sha1Hex("letmein2021") + ":3861493",
+		"  " + strings.ToLower(sha1Hex("qwertyuiop")) + "  ",
+	}, "\n")
+
+	filter, err := LoadBreachedPasswords(writeCorpus(t, corpus), 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filter.Len() != 3 {
+		t.Errorf("Len() = %d; want 3", filter.Len())
+	}
+
+	saved := BreachedPasswords
+	t.Cleanup(func() { BreachedPasswords = saved })
+	BreachedPasswords = filter
+
+	for _, password := range []string{"password123", "letmein2021", "qwertyuiop"} {
+		if !IsBreachedPassword(password) {
+			t.Errorf("IsBreachedPassword(%q) = false; want true", password)
+		}
+	}
+
+	if IsBreachedPassword("correct horse battery staple") {
+		t.Error("IsBreachedPassword() = true for a password not in the corpus")
+	}
+
+	v := validator.New()
+	if ValidateNewPassword(v, "password123"); v.Valid() {
+		t.Error("ValidateNewPassword() accepted a breached password")
+	}
+}
+
+func TestLoadBreachedPasswordsMalformed(t *testing.T) {
+	valid := sha1Hex("password123")
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"plaintext password", "password123"},
+		{"not hex", strings.Repeat("Z", 40)},
+		{"too short", valid[:38]},
+		{"too long", valid + "00"},
+		{"MD5 hash", "482C811DA5D5B4BC6D497FFA98491E38"},
+		{"count only", ":12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBreachedPasswords(writeCorpus(t, valid+"\n"+tt.line+"\n"), 0.001)
+			if err == nil {
+				t.Fatal("LoadBreachedPasswords() accepted a malformed line")
+			}
+
+			if !strings.Contains(err.Error(), "line 2") {
+				t.Errorf("error %q doesn't name line 2", err)
+			}
+		})
+	}
+}
+
+func TestLoadBreachedPasswordsErrors(t *testing.T) {
+	path := writeCorpus(t, sha1Hex("password123"))
+
+	for _, rate := range []float64{0, 1, -0.1} {
+		if _, err := LoadBreachedPasswords(path, rate); err == nil {
+			t.Errorf("LoadBreachedPasswords() accepted a false positive rate of %v", rate)
+		}
+	}
+
+	if _, err := LoadBreachedPasswords(filepath.Join(t.TempDir(), "missing.txt"), 0.001); err == nil {
+		t.Error("LoadBreachedPasswords() accepted a missing file")
+	}
+}
+
+// Without a corpus no password counts as breached
+func TestIsBreachedPasswordNoCorpus(t *testing.T) {
+	saved := BreachedPasswords
+	t.Cleanup(func() { BreachedPasswords = saved })
+	BreachedPasswords = nil
+
+	if IsBreachedPassword("password123") {
+		t.Error("IsBreachedPassword() = true without a corpus")
+	}
+}
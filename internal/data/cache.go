@@ -0,0 +1,301 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache is an in-process cache for the two lookups which happen on every protected
+// request: the user behind an authentication token, and that user's permissions.
+// Entries expire after the TTL, and the models invalidate them whenever the
+// underlying rows change. A nil *Cache is valid and caches nothing.
+//
+// Each API instance has its own cache, so a change made through one instance can
+// take up to the TTL to be seen by the others.
+//
+// A lookup which misses the cache reads the database and then caches what it read. If
+// the user is invalidated in between, caching the result would bring back what was
+// just revoked. So lookups take a generation before reading the database, and the
+// set methods ignore results read before the user's last invalidation.
+type Cache struct {
+	mu  sync.Mutex
+	ttl time.Duration
+
+	// users is keyed by token hash. userTokens maps a user ID back to the token hashes
+	// cached for that user, so they can all be dropped when the user changes.
+	users       map[string]cachedUser
+	userTokens  map[int64]map[string]bool
+	permissions map[int64]cachedPermissions
+
+	// generation is incremented by every invalidation. userGenerations records the
+	// generation of each user's last invalidation, and permissionsGeneration the last
+	// invalidateAllPermissions() call. Results read before horizon are never cached,
+	// which lets removeExpired() forget userGenerations.
+	generation            uint64
+	userGenerations       map[int64]uint64
+	permissionsGeneration uint64
+	horizon               uint64
+
+	stats CacheStats
+}
+
+// CacheStats holds the hit and miss counters for the cache
+type CacheStats struct {
+	UserHits         int64 `json:"user_hits"`
+	UserMisses       int64 `json:"user_misses"`
+	PermissionHits   int64 `json:"permission_hits"`
+	PermissionMisses int64 `json:"permission_misses"`
+}
+
+type cachedUser struct {
+	user   User
+	expiry time.Time
+}
+
+type cachedPermissions struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// NewCache returns a Cache whose entries live for ttl. A ttl of zero or less disables
+// caching altogether.
+func NewCache(ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	c := &Cache{
+		ttl:         ttl,
+		users:       make(map[string]cachedUser),
+		userTokens:  make(map[int64]map[string]bool),
+		permissions: make(map[int64]cachedPermissions),
+
+		userGenerations: make(map[int64]uint64),
+	}
+
+	// Launch a background goroutine which removes expired entries every minute
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			c.removeExpired()
+		}
+	}()
+
+	return c
+}
+
+// Stats returns a snapshot of the hit and miss counters
+func (c *Cache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// snapshot returns the current generation. Take it before reading from the database,
+// and pass it to setUser() or setPermissions() with the result.
+func (c *Cache) snapshot() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// invalidated reports whether the user has been invalidated since the generation was
+// taken. The caller must hold c.mu.
+func (c *Cache) invalidated(userID int64, generation uint64) bool {
+	return generation < c.horizon || c.userGenerations[userID] > generation
+}
+
+// bump records an invalidation of the user. The caller must hold c.mu.
+func (c *Cache) bump(userID int64) {
+	c.generation++
+	c.userGenerations[userID] = c.generation
+}
+
+// getUser returns a copy of the user cached for a token hash
+func (c *Cache) getUser(tokenHash []byte) (*User, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.users[string(tokenHash)]
+	if !ok || !time.Now().Before(entry.expiry) {
+		c.stats.UserMisses++
+		return nil, false
+	}
+
+	c.stats.UserHits++
+	user := entry.user
+	return &user, true
+}
+
+// setUser caches a copy of the user for a token hash, until the TTL passes or the token
+// expires, whichever is first. Nothing is cached if the user has been invalidated since
+// the generation was taken.
+func (c *Cache) setUser(tokenHash []byte, user *User, tokenExpiry time.Time, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.invalidated(user.ID, generation) {
+		return
+	}
+
+	expiry := time.Now().Add(c.ttl)
+	if tokenExpiry.Before(expiry) {
+		expiry = tokenExpiry
+	}
+
+	key := string(tokenHash)
+	c.users[key] = cachedUser{user: *user, expiry: expiry}
+
+	if c.userTokens[user.ID] == nil {
+		c.userTokens[user.ID] = make(map[string]bool)
+	}
+	c.userTokens[user.ID][key] = true
+}
+
+// getPermissions returns a copy of the permissions cached for a user
+func (c *Cache) getPermissions(userID int64) (Permissions, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.permissions[userID]
+	if !ok || !time.Now().Before(entry.expiry) {
+		c.stats.PermissionMisses++
+		return nil, false
+	}
+
+	c.stats.PermissionHits++
+	return append(Permissions(nil), entry.permissions...), true
+}
+
+// setPermissions caches a copy of the permissions for a user. Nothing is cached if the
+// user's permissions have been invalidated since the generation was taken.
+func (c *Cache) setPermissions(userID int64, permissions Permissions, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.invalidated(userID, generation) || c.permissionsGeneration > generation {
+		return
+	}
+
+	c.permissions[userID] = cachedPermissions{
+		permissions: append(Permissions(nil), permissions...),
+		expiry:      time.Now().Add(c.ttl),
+	}
+}
+
+// invalidateToken drops the user cached for a single token hash belonging to the user
+func (c *Cache) invalidateToken(tokenHash []byte, userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bump(userID)
+
+	key := string(tokenHash)
+	if entry, ok := c.users[key]; ok {
+		delete(c.userTokens[entry.user.ID], key)
+		delete(c.users, key)
+	}
+}
+
+// invalidateUser drops everything cached for a user: the user record behind each
+// of their tokens, and their permissions.
+func (c *Cache) invalidateUser(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bump(userID)
+
+	for key := range c.userTokens[userID] {
+		delete(c.users, key)
+	}
+	delete(c.userTokens, userID)
+	delete(c.permissions, userID)
+}
+
+// invalidatePermissions drops the permissions cached for a user
+func (c *Cache) invalidatePermissions(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bump(userID)
+	delete(c.permissions, userID)
+}
+
+// invalidateAllPermissions drops the permissions cached for every user. It's used
+// when a role changes, since that can affect any number of users.
+func (c *Cache) invalidateAllPermissions() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.permissionsGeneration = c.generation
+	c.permissions = make(map[int64]cachedPermissions)
+}
+
+func (c *Cache) removeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	// Forget the recorded invalidations. Results read before now can't be checked
+	// against them any more, so they won't be cached.
+	c.horizon = c.generation
+	c.userGenerations = make(map[int64]uint64)
+
+	for key, entry := range c.users {
+		if !now.Before(entry.expiry) {
+			delete(c.userTokens[entry.user.ID], key)
+			if len(c.userTokens[entry.user.ID]) == 0 {
+				delete(c.userTokens, entry.user.ID)
+			}
+			delete(c.users, key)
+		}
+	}
+
+	for userID, entry := range c.permissions {
+		if !now.Before(entry.expiry) {
+			delete(c.permissions, userID)
+		}
+	}
+}
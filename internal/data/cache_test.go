@@ -0,0 +1,229 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+var testTokenHash = []byte("token-hash")
+
+func TestCacheUser(t *testing.T) {
+	c := NewCache(time.Minute)
+
+	if _, ok := c.getUser(testTokenHash); ok {
+		t.Fatal("getUser() hit on an empty cache")
+	}
+
+	c.setUser(testTokenHash, &User{ID: 1, Name: "Alice"}, time.Now().Add(time.Hour), c.snapshot())
+
+	user, ok := c.getUser(testTokenHash)
+	if !ok || user.ID != 1 || user.Name != "Alice" {
+		t.Fatalf("getUser() = %+v, %t; want user 1, true", user, ok)
+	}
+
+	// Callers get a copy, so changing it doesn't change the cache
+	user.Name = "Bob"
+	if user, _ := c.getUser(testTokenHash); user.Name != "Alice" {
+		t.Errorf("cached user name = %q after changing a copy; want %q", user.Name, "Alice")
+	}
+
+	want := CacheStats{UserHits: 2, UserMisses: 1}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("Stats() = %+v; want %+v", stats, want)
+	}
+}
+
+func TestCacheUserExpiry(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		tokenExpiry time.Duration
+		want        time.Duration
+	}{
+		{"token outlives ttl", time.Minute, time.Hour, time.Minute},
+		{"token expires before ttl", time.Minute, 10 * time.Second, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(tt.ttl)
+
+			now := time.Now()
+			c.setUser(testTokenHash, &User{ID: 1}, now.Add(tt.tokenExpiry), c.snapshot())
+
+			expiry := c.users[string(testTokenHash)].expiry
+			if got := expiry.Sub(now); got < tt.want-time.Second || got > tt.want+time.Second {
+				t.Errorf("entry expires in %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheUserExpiredToken(t *testing.T) {
+	c := NewCache(time.Minute)
+
+	c.setUser(testTokenHash, &User{ID: 1}, time.Now().Add(-time.Second), c.snapshot())
+
+	if _, ok := c.getUser(testTokenHash); ok {
+		t.Error("getUser() hit for an expired token")
+	}
+}
+
+func TestCacheUserTTL(t *testing.T) {
+	c := NewCache(10 * time.Millisecond)
+
+	c.setUser(testTokenHash, &User{ID: 1}, time.Now().Add(time.Hour), c.snapshot())
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := c.getUser(testTokenHash); ok {
+		t.Error("getUser() hit after the TTL")
+	}
+
+	c.removeExpired()
+	if len(c.users) != 0 || len(c.userTokens) != 0 {
+		t.Errorf("removeExpired() left %d users and %d user tokens", len(c.users), len(c.userTokens))
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c := NewCache(time.Minute)
+	expiry := time.Now().Add(time.Hour)
+
+	c.setUser([]byte("a1"), &User{ID: 1}, expiry, c.snapshot())
+	c.setUser([]byte("a2"), &User{ID: 1}, expiry, c.snapshot())
+	c.setUser([]byte("b1"), &User{ID: 2}, expiry, c.snapshot())
+	c.setPermissions(1, Permissions{"movies:read"}, c.snapshot())
+	c.setPermissions(2, Permissions{"movies:read"}, c.snapshot())
+
+	c.invalidateToken([]byte("a1"), 1)
+	if _, ok := c.getUser([]byte("a1")); ok {
+		t.Error("invalidateToken() left the token cached")
+	}
+	if _, ok := c.getUser([]byte("a2")); !ok {
+		t.Error("invalidateToken() dropped the user's other token")
+	}
+
+	c.invalidateUser(1)
+	if _, ok := c.getUser([]byte("a2")); ok {
+		t.Error("invalidateUser() left a token cached")
+	}
+	if _, ok := c.getPermissions(1); ok {
+		t.Error("invalidateUser() left the permissions cached")
+	}
+	if _, ok := c.getUser([]byte("b1")); !ok {
+		t.Error("invalidateUser() dropped another user's token")
+	}
+
+	c.invalidateAllPermissions()
+	if _, ok := c.getPermissions(2); ok {
+		t.Error("invalidateAllPermissions() left permissions cached")
+	}
+}
+
+// A lookup which read the database before an invalidation mustn't cache its result
+// afterwards.
+func TestCacheGeneration(t *testing.T) {
+	expiry := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		invalidate func(c *Cache)
+		cached     bool
+	}{
+		{"no invalidation", func(c *Cache) {}, true},
+		{"user invalidated", func(c *Cache) { c.invalidateUser(1) }, false},
+		{"token invalidated", func(c *Cache) { c.invalidateToken(testTokenHash, 1) }, false},
+		{"other user invalidated", func(c *Cache) { c.invalidateUser(2) }, true},
+		{"cleanup in between", func(c *Cache) { c.invalidateUser(2); c.removeExpired() }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(time.Minute)
+
+			generation := c.snapshot()
+			tt.invalidate(c)
+			c.setUser(testTokenHash, &User{ID: 1}, expiry, generation)
+
+			if _, ok := c.getUser(testTokenHash); ok != tt.cached {
+				t.Errorf("user cached = %t; want %t", ok, tt.cached)
+			}
+
+			// A lookup started afterwards is cached as normal
+			c.setUser(testTokenHash, &User{ID: 1}, expiry, c.snapshot())
+			if _, ok := c.getUser(testTokenHash); !ok {
+				t.Error("user not cached by a later lookup")
+			}
+		})
+	}
+}
+
+func TestCachePermissionsGeneration(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate func(c *Cache)
+		cached     bool
+	}{
+		{"no invalidation", func(c *Cache) {}, true},
+		{"user's permissions invalidated", func(c *Cache) { c.invalidatePermissions(1) }, false},
+		{"all permissions invalidated", func(c *Cache) { c.invalidateAllPermissions() }, false},
+		{"other user's permissions invalidated", func(c *Cache) { c.invalidatePermissions(2) }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(time.Minute)
+
+			generation := c.snapshot()
+			tt.invalidate(c)
+			c.setPermissions(1, Permissions{"movies:read"}, generation)
+
+			if _, ok := c.getPermissions(1); ok != tt.cached {
+				t.Errorf("permissions cached = %t; want %t", ok, tt.cached)
+			}
+		})
+	}
+}
+
+func TestCachePermissionsCopy(t *testing.T) {
+	c := NewCache(time.Minute)
+
+	permissions := Permissions{"movies:read"}
+	c.setPermissions(1, permissions, c.snapshot())
+	permissions[0] = "users:admin"
+
+	got, ok := c.getPermissions(1)
+	if !ok || len(got) != 1 || got[0] != "movies:read" {
+		t.Fatalf("getPermissions() = %v, %t; want [movies:read], true", got, ok)
+	}
+
+	got[0] = "users:admin"
+	if got, _ := c.getPermissions(1); got[0] != "movies:read" {
+		t.Errorf("cached permissions changed through a copy: %v", got)
+	}
+}
+
+// A nil cache is valid and caches nothing
+func TestNilCache(t *testing.T) {
+	c := NewCache(0)
+	if c != nil {
+		t.Fatal("NewCache(0) is not nil")
+	}
+
+	c.setUser(testTokenHash, &User{ID: 1}, time.Now().Add(time.Hour), c.snapshot())
+	c.setPermissions(1, Permissions{"movies:read"}, c.snapshot())
+	c.invalidateToken(testTokenHash, 1)
+	c.invalidateUser(1)
+	c.invalidatePermissions(1)
+	c.invalidateAllPermissions()
+
+	if _, ok := c.getUser(testTokenHash); ok {
+		t.Error("nil cache getUser() hit")
+	}
+	if _, ok := c.getPermissions(1); ok {
+		t.Error("nil cache getPermissions() hit")
+	}
+	if stats := c.Stats(); stats != (CacheStats{}) {
+		t.Errorf("nil cache Stats() = %+v", stats)
+	}
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DenyListEntry revokes signed (JWT) authentication tokens before they expire. An
+// entry with a JTI revokes that one token, and an entry with a Family revokes every
+// token in a session. An entry with a UserID revokes every token issued to the user
+// before IssuedBefore.
+type DenyListEntry struct {
+	JTI          string
+	Family       []byte
+	UserID       int64
+	IssuedBefore time.Time
+	Expiry       time.Time
+}
+
+type DenyListModel struct {
+	DB *sql.DB
+}
+
+// Insert adds an entry to the deny-list
+func (m DenyListModel) Insert(entry *DenyListEntry) error {
+	query := `
+	INSERT INTO jwt_denylist (jti, family, user_id, issued_before, expiry)
+	VALUES (NULLIF($1, ''), NULLIF($2, ''::bytea), NULLIF($3, 0), $4, $5)`
+
+	// Leave issued_before NULL for single token entries
+	var issuedBefore *time.Time
+	if !entry.IssuedBefore.IsZero() {
+		issuedBefore = &entry.IssuedBefore
+	}
+
+	args := []interface{}{entry.JTI, entry.Family, entry.UserID, issuedBefore, entry.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAllActive returns every entry which hasn't expired yet
+func (m DenyListModel) GetAllActive() ([]*DenyListEntry, error) {
+	query := `
+	SELECT COALESCE(jti, ''), family, COALESCE(user_id, 0), COALESCE(issued_before, 'epoch'), expiry
+	FROM jwt_denylist
+	WHERE expiry > $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*DenyListEntry{}
+
+	for rows.Next() {
+		var entry DenyListEntry
+
+		err := rows.Scan(&entry.JTI, &entry.Family, &entry.UserID, &entry.IssuedBefore, &entry.Expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// DeleteExpired deletes up to batchSize expired entries, and returns how many were
+// deleted. The tokens they revoked have expired too, so they're no longer needed.
+func (m DenyListModel) DeleteExpired(batchSize int) (int64, error) {
+	query := `
+	DELETE FROM jwt_denylist
+	WHERE id IN (
+		SELECT id FROM jwt_denylist
+		WHERE expiry < $1
+		LIMIT $2
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
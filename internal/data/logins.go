@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrLoginBackoff = errors.New("login backing off")
+)
+
+// LoginFailure counts the failed login attempts for an account or an IP address
+type LoginFailure struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// AccountLoginKey returns the LoginFailure key for an email address. Addresses which
+// don't belong to an account are tracked too, so their responses look the same.
+func AccountLoginKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+// IPLoginKey returns the LoginFailure key for a client IP address
+func IPLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// Get returns the failed login attempts recorded for a key
+func (m LoginFailureModel) Get(key string) (*LoginFailure, error) {
+	query := `
+	SELECT key, failures, last_failure_at, locked_until
+	FROM login_failures
+	WHERE key = $1`
+
+	var failure LoginFailure
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(
+		&failure.Key,
+		&failure.Failures,
+		&failure.LastFailureAt,
+		&failure.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &failure, nil
+}
+
+// LoginLimits controls how failed logins for a key back off. After each failure the key
+// has to wait BackoffBase, doubling with every further failure, up to Lockout. LockAfter
+// failures lock the key for the whole Lockout. Failures are forgotten once the last one
+// is older than Lockout.
+type LoginLimits struct {
+	LockAfter   int
+	BackoffBase time.Duration
+	Lockout     time.Duration
+}
+
+// Attempt counts a login attempt for a key, as a failure, unless the key is backing off.
+// Attempts are counted before the password is checked, so the check and the count happen
+// in a single statement and parallel requests can't all get through before the first
+// failure is recorded. Call Release or Delete once the attempt turns out not to be a
+// failure. It returns ErrLoginBackoff, and counts nothing, when the key is backing off.
+func (m LoginFailureModel) Attempt(key string, limits LoginLimits) (*LoginFailure, error) {
+	// The WHERE clause on the update must agree with loginBackoff() in cmd/api. $3 and
+	// $4 are the base delay and the lockout in seconds.
+	query := `
+	INSERT INTO login_failures (key, failures, last_failure_at, locked_until)
+	VALUES ($1, 1, NOW(), CASE WHEN $2::integer <= 1 THEN NOW() + $4::float8 * interval '1 second' END)
+	ON CONFLICT (key) DO UPDATE
+	SET failures = CASE
+			WHEN login_failures.last_failure_at <= NOW() - $4::float8 * interval '1 second' THEN 1
+			ELSE login_failures.failures + 1
+		END,
+		last_failure_at = NOW(),
+		locked_until = CASE
+			WHEN CASE
+				WHEN login_failures.last_failure_at <= NOW() - $4::float8 * interval '1 second' THEN 1
+				ELSE login_failures.failures + 1
+			END >= $2::integer THEN NOW() + $4::float8 * interval '1 second'
+		END
+	WHERE (login_failures.locked_until IS NULL OR login_failures.locked_until <= NOW())
+	AND (login_failures.failures < 1 OR login_failures.last_failure_at +
+		LEAST($3::float8 * power(2, LEAST(login_failures.failures, 32) - 1), $4::float8) * interval '1 second' <= NOW())
+	RETURNING key, failures, last_failure_at, locked_until`
+
+	args := []interface{}{key, limits.LockAfter, limits.BackoffBase.Seconds(), limits.Lockout.Seconds()}
+
+	var failure LoginFailure
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&failure.Key,
+		&failure.Failures,
+		&failure.LastFailureAt,
+		&failure.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		// The update's WHERE clause didn't match, so the key is backing off
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrLoginBackoff
+		default:
+			return nil, err
+		}
+	}
+
+	return &failure, nil
+}
+
+// Release takes back an attempt counted by Attempt, for when it didn't fail, such as a
+// successful login. Unlike Delete, the key keeps the failures it had before the attempt.
+// lockAfter must be the value the attempt was made with, so a lock set by the attempt is
+// lifted again.
+func (m LoginFailureModel) Release(key string, lockAfter int) error {
+	query := `
+	UPDATE login_failures
+	SET failures = GREATEST(failures - 1, 0),
+		locked_until = CASE WHEN failures - 1 >= $2 THEN locked_until END
+	WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, lockAfter)
+	return err
+}
+
+// Delete resets the failed login attempts for a key
+func (m LoginFailureModel) Delete(key string) error {
+	query := `
+	DELETE FROM login_failures
+	WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
@@ -22,15 +22,35 @@ type Models struct {
 	Users       UserModel
 	Token       TokenModel
 	Permissions PermissionModel
+	Roles       RoleModel
+	APIKeys     APIKeyModel
+	DenyList    DenyListModel
+	Sessions    SessionModel
+	TOTP        TOTPModel
+	Logins      LoginFailureModel
+	Reviews     ReviewModel
+	Watchlist   WatchlistModel
+	People      PersonModel
 }
 
 // Creates a Models that holds all of our database models.
-func NewModels(db *sql.DB) Models {
+// The cache is shared by the models which read or change users, tokens and
+// permissions, so they can keep it up to date. It may be nil.
+func NewModels(db *sql.DB, cache *Cache) Models {
 	return Models{
 		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Token:       TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Users:       UserModel{DB: db, Cache: cache},
+		Token:       TokenModel{DB: db, Cache: cache},
+		Permissions: PermissionModel{DB: db, Cache: cache},
+		Roles:       RoleModel{DB: db, Cache: cache},
+		APIKeys:     APIKeyModel{DB: db},
+		DenyList:    DenyListModel{DB: db},
+		Sessions:    SessionModel{DB: db},
+		TOTP:        TOTPModel{DB: db},
+		Logins:      LoginFailureModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
+		Watchlist:   WatchlistModel{DB: db},
+		People:      PersonModel{DB: db},
 	}
 }
 
@@ -46,6 +66,9 @@ type Movie struct {
 	// If you want to use omitempty and not change the key name then you can leave it blank in the struct tag — like this: json:",omitempty". Notice that the leading comma is still required.
 	Genres  []string `json:"genres,omitempty"`
 	Version int32    `json:"version"` // incremented everytime the movie info is updated
+	// Aggregated from the movie's reviews. AverageRating is nil until the movie has been reviewed.
+	AverageRating *float64 `json:"average_rating"`
+	ReviewCount   int      `json:"review_count"`
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -66,6 +89,53 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
 }
 
+// MovieFilters narrows down the movies returned by MovieModel.GetAll. Zero values
+// mean "don't filter on this".
+type MovieFilters struct {
+	Title         string
+	Genres        []string
+	GenresMode    string // "all" of Genres, or "any" of them
+	ExcludeGenres []string
+	PersonID      int64 // only movies the person is credited on
+	YearMin       int
+	YearMax       int
+	RuntimeMin    int // in minutes
+	RuntimeMax    int
+}
+
+// Genre match modes for MovieFilters
+const (
+	GenresModeAll = "all"
+	GenresModeAny = "any"
+)
+
+func ValidateMovieFilters(v *validator.Validator, f MovieFilters) {
+	v.Check(validator.In(f.GenresMode, GenresModeAll, GenresModeAny), "genres_mode", "must be all or any")
+
+	v.Check(len(f.ExcludeGenres) <= 20, "exclude_genres", "must not contain more than 20 genres")
+	for _, genre := range f.ExcludeGenres {
+		v.Check(!validator.In(genre, f.Genres...), "exclude_genres", "must not contain genres which are also in genres")
+	}
+
+	v.Check(f.PersonID >= 0, "person_id", "must be a positive integer")
+
+	v.Check(f.YearMin == 0 || f.YearMin >= 1888, "year_min", "must be greater than 1888")
+	v.Check(f.YearMin <= time.Now().Year(), "year_min", "must not be in the future")
+	v.Check(f.YearMax == 0 || f.YearMax >= 1888, "year_max", "must be greater than 1888")
+	v.Check(f.YearMax == 0 || f.YearMax >= f.YearMin, "year_max", "must not be less than year_min")
+
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "must be a positive integer")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "must be a positive integer")
+	v.Check(f.RuntimeMax == 0 || f.RuntimeMax >= f.RuntimeMin, "runtime_max", "must not be less than runtime_min")
+}
+
+// ratingsSubquery aggregates the reviews of the movie in the outer query. The average
+// is rounded to one decimal place, and is NULL when there are no reviews.
+const ratingsSubquery = `
+	SELECT ROUND(AVG(reviews.rating), 1)::float8 AS average, COUNT(*) AS count
+	FROM reviews
+	WHERE reviews.movie_id = movies.id`
+
 // MovieModel wraps our db connection
 type MovieModel struct {
 	DB *sql.DB
@@ -89,8 +159,9 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 	// stmt := `SELECT pg_sleep(10),id,created_at,title,year,runtime,genres,version
 	// 				 FROM movies
 	// 				 WHERE id = $1`
-	stmt := `SELECT id,created_at,title,year,runtime,genres,version
+	stmt := `SELECT id,created_at,title,year,runtime,genres,version,ratings.average,ratings.count
 					 FROM movies
+					 CROSS JOIN LATERAL (` + ratingsSubquery + `) AS ratings
 					 WHERE id = $1`
 	// declare a movie
 	var movie Movie
@@ -121,6 +192,8 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.ReviewCount,
 	)
 
 	if err != nil {
@@ -136,7 +209,7 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 }
 
 // GetAll returns a slice of Movies.
-func (m *MovieModel) GetAll(title string, gernres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m *MovieModel) GetAll(movieFilters MovieFilters, filters Filters) ([]*Movie, Metadata, error) {
 	// Sql Query
 	// query := `
 	// SELECT id, created_at, title, year, runtime, genres, version
@@ -198,21 +271,39 @@ func (m *MovieModel) GetAll(title string, gernres []string, filters Filters) ([]
 	// notice that we also include a secondary sort on the movie ID to ensure a
 	// consistent ordering.
 	// Added the window function to count the number of (filtered) records
+	// The genre conditions use the ‘contains’ (@>) and ‘overlaps’ (&&) array operators,
+	// which the movies_genres_idx GIN index supports. $8 picks which of the first two
+	// applies. An empty exclude list overlaps nothing, so it excludes nothing.
 	query := fmt.Sprintf(`
-	SELECT COUNT(*) OVER(),id, created_at, title, year, runtime, genres, version
+	SELECT COUNT(*) OVER(),id, created_at, title, year, runtime, genres, version, ratings.average, ratings.count
 	FROM movies
+	CROSS JOIN LATERAL (`+ratingsSubquery+`) AS ratings
 	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-	AND (genres @> $2 OR $2 = '{}')     
+	AND (genres @> $2 OR $2 = '{}' OR $8 = 'any')
+	AND (genres && $2 OR $2 = '{}' OR $8 = 'all')
+	AND NOT (genres && $9)
+	AND (EXISTS (SELECT 1 FROM movie_credits WHERE movie_credits.movie_id = movies.id AND movie_credits.person_id = $3) OR $3 = 0)
+	AND (year >= $4 OR $4 = 0)
+	AND (year <= $5 OR $5 = 0)
+	AND (runtime >= $6 OR $6 = 0)
+	AND (runtime <= $7 OR $7 = 0)
 	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	LIMIT $10 OFFSET $11`, filters.sortColumn(), filters.sortDirection())
 
 	// 3 second context timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	args := []interface{}{
-		title,
-		pq.Array(gernres),
+		movieFilters.Title,
+		pq.Array(movieFilters.Genres),
+		movieFilters.PersonID,
+		movieFilters.YearMin,
+		movieFilters.YearMax,
+		movieFilters.RuntimeMin,
+		movieFilters.RuntimeMax,
+		movieFilters.GenresMode,
+		pq.Array(movieFilters.ExcludeGenres),
 		filters.limit(),
 		filters.offset(),
 	}
@@ -245,6 +336,8 @@ func (m *MovieModel) GetAll(title string, gernres []string, filters Filters) ([]
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -324,6 +417,7 @@ func (m *MovieModel) Update(movie *Movie) error {
 }
 
 // Delete
+// Reviews, watchlist entries and credits for the movie are removed by ON DELETE CASCADE foreign keys.
 func (m *MovieModel) Delete(id int64) error {
 	// ids can't be less than 1
 	if id < 1 {
@@ -0,0 +1,203 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	ErrInvalidPasswordHash = errors.New("invalid password hash")
+)
+
+// PasswordHashing holds the algorithm and parameters used to hash new passwords. Hashes
+// record the parameters they were made with, so changing these doesn't affect existing
+// passwords, which are re-hashed the next time their owner logs in.
+var PasswordHashing = PasswordParams{
+	Algorithm:         AlgorithmArgon2id,
+	BcryptCost:        12,
+	Argon2Memory:      64 * 1024,
+	Argon2Iterations:  1,
+	Argon2Parallelism: 2,
+	Argon2SaltLength:  16,
+	Argon2KeyLength:   32,
+}
+
+type PasswordParams struct {
+	Algorithm  string
+	BcryptCost int
+	// Argon2Memory is in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	Argon2SaltLength  uint32
+	Argon2KeyLength   uint32
+}
+
+// Validate checks that the parameters can be used to hash passwords
+func (p PasswordParams) Validate() error {
+	switch p.Algorithm {
+	case AlgorithmArgon2id:
+		if p.Argon2Memory < 8*uint32(p.Argon2Parallelism) || p.Argon2Iterations < 1 || p.Argon2Parallelism < 1 {
+			return fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d", p.Argon2Memory, p.Argon2Iterations, p.Argon2Parallelism)
+		}
+		if p.Argon2SaltLength < 8 || p.Argon2KeyLength < 16 {
+			return errors.New("argon2id salt must be at least 8 bytes and key at least 16 bytes")
+		}
+	case AlgorithmBcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("unsupported password hashing algorithm %q", p.Algorithm)
+	}
+
+	return nil
+}
+
+// argon2Hash is a decoded argon2id hash in the PHC string format:
+// $argon2id$v=19$m=65536,t=1,p=2$<base64 salt>$<base64 key>
+type argon2Hash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// hashPassword hashes a plaintext password with the given parameters
+func hashPassword(plaintext string, params PasswordParams) ([]byte, error) {
+	switch params.Algorithm {
+	case AlgorithmBcrypt:
+		return bcrypt.GenerateFromPassword([]byte(plaintext), params.BcryptCost)
+
+	case AlgorithmArgon2id:
+		salt := make([]byte, params.Argon2SaltLength)
+
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, err
+		}
+
+		key := argon2.IDKey([]byte(plaintext), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, params.Argon2KeyLength)
+
+		encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version,
+			params.Argon2Memory,
+			params.Argon2Iterations,
+			params.Argon2Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		)
+
+		return []byte(encoded), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported password hashing algorithm %q", params.Algorithm)
+	}
+}
+
+// comparePassword checks a plaintext password against a hash made by either algorithm
+func comparePassword(hash []byte, plaintext string) (bool, error) {
+	if !isArgon2Hash(hash) {
+		err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+		if err != nil {
+			switch {
+			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	decoded, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), decoded.salt, decoded.iterations, decoded.memory, decoded.parallelism, uint32(len(decoded.key)))
+
+	return subtle.ConstantTimeCompare(key, decoded.key) == 1, nil
+}
+
+// passwordNeedsRehash reports whether a hash was made with a different algorithm or
+// parameters than the ones given.
+func passwordNeedsRehash(hash []byte, params PasswordParams) (bool, error) {
+	if !isArgon2Hash(hash) {
+		if params.Algorithm != AlgorithmBcrypt {
+			return true, nil
+		}
+
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return false, err
+		}
+		return cost != params.BcryptCost, nil
+	}
+
+	if params.Algorithm != AlgorithmArgon2id {
+		return true, nil
+	}
+
+	decoded, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	return decoded.memory != params.Argon2Memory ||
+		decoded.iterations != params.Argon2Iterations ||
+		decoded.parallelism != params.Argon2Parallelism ||
+		uint32(len(decoded.salt)) != params.Argon2SaltLength ||
+		uint32(len(decoded.key)) != params.Argon2KeyLength, nil
+}
+
+func isArgon2Hash(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$argon2id$")
+}
+
+func decodeArgon2Hash(hash []byte) (*argon2Hash, error) {
+	// The leading $ gives an empty first part
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	var decoded argon2Hash
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &decoded.memory, &decoded.iterations, &decoded.parallelism)
+	if err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	decoded.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(decoded.key) == 0 {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	return &decoded, nil
+}
@@ -0,0 +1,99 @@
+package data
+
+import "testing"
+
+// withAlgorithm hashes new passwords with the given algorithm, and the server's default
+// parameters for it, until the test ends.
+func withAlgorithm(tb testing.TB, algorithm string) {
+	tb.Helper()
+
+	saved := PasswordHashing
+	tb.Cleanup(func() { PasswordHashing = saved })
+
+	PasswordHashing.Algorithm = algorithm
+}
+
+func TestPasswordMatches(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			withAlgorithm(t, algorithm)
+
+			var p password
+			if err := p.Set("pa55word1234"); err != nil {
+				t.Fatal(err)
+			}
+
+			ok, err := p.Matches("pa55word1234")
+			if err != nil || !ok {
+				t.Errorf("Matches(correct password) = %t, %v; want true, nil", ok, err)
+			}
+
+			ok, err = p.Matches("pa55word12345")
+			if err != nil || ok {
+				t.Errorf("Matches(wrong password) = %t, %v; want false, nil", ok, err)
+			}
+		})
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	withAlgorithm(t, AlgorithmBcrypt)
+
+	var p password
+	if err := p.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rehash, err := p.NeedsRehash(); err != nil || rehash {
+		t.Errorf("NeedsRehash() with unchanged settings = %t, %v; want false, nil", rehash, err)
+	}
+
+	PasswordHashing.Algorithm = AlgorithmArgon2id
+	if rehash, err := p.NeedsRehash(); err != nil || !rehash {
+		t.Errorf("NeedsRehash() after switching to argon2id = %t, %v; want true, nil", rehash, err)
+	}
+
+	if err := p.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	PasswordHashing.Argon2Iterations++
+	if rehash, err := p.NeedsRehash(); err != nil || !rehash {
+		t.Errorf("NeedsRehash() after changing argon2id iterations = %t, %v; want true, nil", rehash, err)
+	}
+}
+
+func TestComparePasswordInvalidHash(t *testing.T) {
+	_, err := comparePassword([]byte("$argon2id$v=19$m=65536,t=1$c2FsdA$a2V5"), "pa55word1234")
+	if err != ErrInvalidPasswordHash {
+		t.Errorf("comparePassword(malformed hash) error = %v; want %v", err, ErrInvalidPasswordHash)
+	}
+}
+
+// The benchmarks measure the cost of checking a password at login, using the default
+// parameters the server hashes new passwords with.
+func BenchmarkPasswordMatchesBcrypt(b *testing.B) {
+	benchmarkPasswordMatches(b, AlgorithmBcrypt)
+}
+
+func BenchmarkPasswordMatchesArgon2id(b *testing.B) {
+	benchmarkPasswordMatches(b, AlgorithmArgon2id)
+}
+
+func benchmarkPasswordMatches(b *testing.B, algorithm string) {
+	withAlgorithm(b, algorithm)
+
+	var p password
+	if err := p.Set("pa55word1234"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ok, err := p.Matches("pa55word1234")
+		if err != nil || !ok {
+			b.Fatalf("Matches() = %t, %v", ok, err)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+)
+
+// Credit roles
+const (
+	RoleDirector = "director"
+	RoleWriter   = "writer"
+	RoleActor    = "actor"
+)
+
+// Person is somebody who worked on a movie
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Version   int32     `json:"version"`
+}
+
+// Credit links a person to a movie with the role they had. Character is only set for
+// actors.
+type Credit struct {
+	ID        int64  `json:"id"`
+	MovieID   int64  `json:"movie_id"`
+	PersonID  int64  `json:"person_id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Character string `json:"character,omitempty"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, RoleDirector, RoleWriter, RoleActor), "role", "must be director, writer or actor")
+
+	v.Check(credit.Role == RoleActor || credit.Character == "", "character", "must only be provided for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+}
+
+type PersonModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a person
+func (m PersonModel) Insert(person *Person) error {
+	query := `
+	INSERT INTO people (name)
+	VALUES ($1)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, person.Name).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+// Get returns a person
+func (m PersonModel) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, name, version
+	FROM people
+	WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&person.ID, &person.CreatedAt, &person.Name, &person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// GetAll returns a page of people, optionally only those whose name matches the given
+// words. It works like the title search in MovieModel.GetAll.
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) OVER(), id, created_at, name, version
+	FROM people
+	WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(&totalRecords, &person.ID, &person.CreatedAt, &person.Name, &person.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+// Update changes a person. It returns ErrEditConflict if the person has changed since
+// it was read.
+func (m PersonModel) Update(person *Person) error {
+	query := `
+	UPDATE people
+	SET name = $1, version = version + 1
+	WHERE id = $2 AND version = $3
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, person.Name, person.ID, person.Version).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a person. Their credits are removed by an ON DELETE CASCADE foreign key.
+func (m PersonModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM people
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// InsertCredit adds a credit to a movie. It returns ErrRecordNotFound if the person
+// doesn't exist, and ErrDuplicateCredit if the movie already has the same credit.
+func (m PersonModel) InsertCredit(credit *Credit) error {
+	// Inserting from a SELECT on people inserts nothing, instead of failing on the
+	// foreign key, when the person doesn't exist.
+	query := `
+	WITH credit AS (
+		INSERT INTO movie_credits (movie_id, person_id, role, character)
+		SELECT $1, people.id, $3, $4
+		FROM people
+		WHERE people.id = $2
+		RETURNING id, person_id
+	)
+	SELECT credit.id, people.name
+	FROM credit
+	INNER JOIN people ON people.id = credit.person_id`
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_credits_movie_id_person_id_role_character_key"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetCreditsForMovie returns a movie's credits. Directors come first, then writers,
+// then actors, each in the order they were added.
+func (m PersonModel) GetCreditsForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+	SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+		movie_credits.role, movie_credits.character
+	FROM movie_credits
+	INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE movie_credits.movie_id = $1
+	ORDER BY CASE movie_credits.role WHEN 'director' THEN 1 WHEN 'writer' THEN 2 ELSE 3 END,
+		movie_credits.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(&credit.ID, &credit.MovieID, &credit.PersonID, &credit.Name, &credit.Role, &credit.Character)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// DeleteCredit removes a credit from a movie
+func (m PersonModel) DeleteCredit(movieID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM movie_credits
+	WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -10,30 +11,135 @@ import (
 
 // Define a permissions slice, which will be used to hold the permission codes
 // like (movies:read) and (movies:write)
+// Codes have the form "resource:action". A granted code may also be a wildcard:
+// "movies:*" matches every movies code, and "*" matches everything.
 type Permissions []string
 
-// Include Helper method to check whether the Permissions slice contains a specific permission code
+// impliedPermissions is the one place where we define which permission codes imply
+// others. A user granted a key here also has every code in its value.
+var impliedPermissions = map[string][]string{
+	"movies:write":     {"movies:read"},
+	"reviews:moderate": {"reviews:write"},
+}
+
+// Include Helper method to check whether the Permissions slice grants a specific
+// permission code, either exactly, through a wildcard, or through an implied permission.
 func (p Permissions) Include(code string) bool {
-	for i := range p {
-		if code == p[i] {
+	for _, granted := range p.expand() {
+		if permissionMatches(granted, code) {
 			return true
 		}
 	}
 	return false
 }
 
+// expand returns the granted codes together with every code they imply,
+// following implications transitively.
+func (p Permissions) expand() []string {
+	seen := make(map[string]bool)
+	var codes []string
+
+	queue := append([]string{}, p...)
+	for len(queue) > 0 {
+		code := queue[0]
+		queue = queue[1:]
+
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+
+		queue = append(queue, impliedPermissions[code]...)
+	}
+
+	return codes
+}
+
+// permissionMatches reports whether a granted code (which may be a wildcard) covers
+// the required code. "*" covers everything, and "movies:*" covers every code which
+// starts with "movies:", including nested ones like "movies:reviews:write". An empty
+// required code never matches.
+func permissionMatches(granted, required string) bool {
+	switch {
+	case required == "":
+		return false
+	case granted == required:
+		return true
+	case granted == "*":
+		return true
+	case strings.HasSuffix(granted, ":*"):
+		return strings.HasPrefix(required, strings.TrimSuffix(granted, "*"))
+	default:
+		return false
+	}
+}
+
 type PermissionModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *Cache
 }
 
-// GetAllForUser returns all permission codes for a specific user in a Permissions slice
+// GetAll returns every permission code that can be granted
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+	SELECT code
+	FROM permissions
+	ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// GetAllForUser returns all permission codes for a specific user in a Permissions slice.
+// This is the union of the codes granted to the user directly and the codes bundled
+// in any roles assigned to them.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	// requirePermission() calls this on every protected request, so check the cache first
+	if permissions, ok := m.Cache.getPermissions(userID); ok {
+		return permissions, nil
+	}
+
+	// Taken before the query, so a result which raced with a permission change isn't
+	// cached
+	generation := m.Cache.snapshot()
+
 	query := `
 	SELECT permissions.code
 	FROM permissions
 	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-	INNER JOIN users ON users_permissions.user_id = users.id
-	WHERE users.id = $1`
+	WHERE users_permissions.user_id = $1
+	UNION
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+	INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+	WHERE users_roles.user_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -61,6 +167,7 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		return nil, err
 	}
 
+	m.Cache.setPermissions(userID, permissions, generation)
 	return permissions, nil
 }
 
@@ -75,12 +182,42 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	// ‘interim’ table with rows made up of the user ID and the corresponding IDsfor the permission
 	// codesin the array. Then we insert the contents of this interim table into our
 	// user_permissions table.
+	//
+	// ON CONFLICT DO NOTHING makes this idempotent, so granting a permission the user
+	// already has is not an error.
 	query := `
 	INSERT INTO users_permissions
-	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	if err != nil {
+		return err
+	}
+
+	m.Cache.invalidatePermissions(userID)
+	return nil
+}
+
+// RemoveForUser revokes the provided permission codes from a specific user. Codes the
+// user doesn't have are ignored, so this is idempotent just like AddForUser.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+	DELETE FROM users_permissions
+	USING permissions
+	WHERE users_permissions.permission_id = permissions.id
+	AND users_permissions.user_id = $1
+	AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	m.Cache.invalidatePermissions(userID)
+	return nil
 }
@@ -0,0 +1,51 @@
+package data
+
+import "testing"
+
+func TestPermissionsInclude(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions Permissions
+		code        string
+		want        bool
+	}{
+		{"exact match", Permissions{"movies:read"}, "movies:read", true},
+		{"exact mismatch", Permissions{"movies:read"}, "users:admin", false},
+		{"star matches anything", Permissions{"*"}, "users:admin", true},
+		{"resource wildcard matches its codes", Permissions{"movies:*"}, "movies:read", true},
+		{"resource wildcard matches nested codes", Permissions{"movies:*"}, "movies:reviews:write", true},
+		{"resource wildcard doesn't match other resources", Permissions{"movies:*"}, "users:admin", false},
+		{"resource wildcard doesn't match a shared prefix", Permissions{"movies:*"}, "moviesx:read", false},
+		{"write implies read", Permissions{"movies:write"}, "movies:read", true},
+		{"read doesn't imply write", Permissions{"movies:read"}, "movies:write", false},
+		{"moderate implies review write", Permissions{"reviews:moderate"}, "reviews:write", true},
+		{"movies read doesn't allow review write", Permissions{"movies:read"}, "reviews:write", false},
+		{"empty code", Permissions{"movies:read"}, "", false},
+		{"empty code with star", Permissions{"*"}, "", false},
+		{"unknown code", Permissions{"movies:write", "users:admin"}, "reviews:moderate", false},
+		{"no permissions", Permissions{}, "movies:read", false},
+		{"nil permissions", nil, "movies:read", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.permissions.Include(tt.code); got != tt.want {
+				t.Errorf("%v.Include(%q) = %t; want %t", tt.permissions, tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissionsExpand(t *testing.T) {
+	got := Permissions{"movies:write", "movies:write", "users:admin"}.expand()
+	want := []string{"movies:write", "users:admin", "movies:read"}
+
+	if len(got) != len(want) {
+		t.Fatalf("expand() = %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expand() = %v; want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+// Review is a user's rating of a movie, out of 10, with optional text. Each user can
+// review a movie once.
+type Review struct {
+	ID         int64     `json:"id"`
+	MovieID    int64     `json:"movie_id"`
+	UserID     int64     `json:"user_id"`
+	AuthorName string    `json:"author_name"`
+	Rating     int       `json:"rating"`
+	Text       string    `json:"text,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Version    int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating != 0, "rating", "must be provided")
+	v.Check(review.Rating >= 1 && review.Rating <= 10, "rating", "must be between 1 and 10")
+
+	v.Check(len(review.Text) <= 10_000, "text", "must not be more than 10000 bytes long")
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a review. It returns ErrDuplicateReview if the user has already reviewed
+// the movie.
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+	WITH review AS (
+		INSERT INTO reviews (movie_id, user_id, rating, text)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, created_at, updated_at, version
+	)
+	SELECT review.id, users.name, review.created_at, review.updated_at, review.version
+	FROM review
+	INNER JOIN users ON users.id = review.user_id`
+
+	args := []interface{}{review.MovieID, review.UserID, review.Rating, review.Text}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.AuthorName, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns a review of a movie
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT reviews.id, reviews.movie_id, reviews.user_id, users.name, reviews.rating, reviews.text,
+		reviews.created_at, reviews.updated_at, reviews.version
+	FROM reviews
+	INNER JOIN users ON users.id = reviews.user_id
+	WHERE reviews.id = $1 AND reviews.movie_id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, movieID).Scan(
+		&review.ID,
+		&review.MovieID,
+		&review.UserID,
+		&review.AuthorName,
+		&review.Rating,
+		&review.Text,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForMovie returns a page of a movie's reviews
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) OVER(), reviews.id, reviews.movie_id, reviews.user_id, users.name, reviews.rating,
+		reviews.text, reviews.created_at, reviews.updated_at, reviews.version
+	FROM reviews
+	INNER JOIN users ON users.id = reviews.user_id
+	WHERE reviews.movie_id = $1
+	ORDER BY reviews.%s %s, reviews.id ASC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	return m.query(query, filters, movieID, filters.limit(), filters.offset())
+}
+
+// GetAllForUser returns every review written by a user, newest first
+func (m ReviewModel) GetAllForUser(userID int64) ([]*Review, error) {
+	query := `
+	SELECT COUNT(*) OVER(), reviews.id, reviews.movie_id, reviews.user_id, users.name, reviews.rating,
+		reviews.text, reviews.created_at, reviews.updated_at, reviews.version
+	FROM reviews
+	INNER JOIN users ON users.id = reviews.user_id
+	WHERE reviews.user_id = $1
+	ORDER BY reviews.created_at DESC, reviews.id DESC`
+
+	reviews, _, err := m.query(query, Filters{}, userID)
+	return reviews, err
+}
+
+// query runs a query which selects a row count followed by the review columns. The
+// pagination metadata is only calculated when filters has a page size.
+func (m ReviewModel) query(query string, filters Filters, args ...interface{}) ([]*Review, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.MovieID,
+			&review.UserID,
+			&review.AuthorName,
+			&review.Rating,
+			&review.Text,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var metadata Metadata
+	if filters.PageSize > 0 {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	return reviews, metadata, nil
+}
+
+// Update changes a review's rating and text, using the version number to detect
+// concurrent edits.
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+	UPDATE reviews
+	SET rating = $1, text = $2, updated_at = NOW(), version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING updated_at, version`
+
+	args := []interface{}{review.Rating, review.Text, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a review
+func (m ReviewModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM reviews
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
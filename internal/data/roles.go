@@ -0,0 +1,290 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateRoleName = errors.New("duplicate role name")
+)
+
+// Role bundles a set of permission codes under a name, like "viewer" or "editor",
+// so they can be assigned to users together.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+}
+
+type RoleModel struct {
+	DB    *sql.DB
+	Cache *Cache
+}
+
+// GetAll returns every role along with the permission codes it bundles
+func (m RoleModel) GetAll() ([]*Role, error) {
+	// array_remove() drops the NULL produced by the LEFT JOIN for roles without permissions
+	query := `
+	SELECT roles.id, roles.name, array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+	FROM roles
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+	GROUP BY roles.id
+	ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.ID, &role.Name, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Get returns a specific role along with the permission codes it bundles
+func (m RoleModel) Get(id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT roles.id, roles.name, array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+	FROM roles
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+	WHERE roles.id = $1
+	GROUP BY roles.id`
+
+	var role Role
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&role.ID, &role.Name, pq.Array(&role.Permissions))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &role, nil
+}
+
+// addRolePermissionsQuery adds the permission codes in $2 to the role with ID $1. Codes
+// the role already has are ignored.
+const addRolePermissionsQuery = `
+	INSERT INTO roles_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING`
+
+// Insert adds a new role, along with its permission codes. Both are added in one
+// transaction, so a failure can't leave a role without its permissions behind.
+func (m RoleModel) Insert(role *Role) error {
+	query := `
+	INSERT INTO roles (name)
+	VALUES ($1)
+	RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, role.Name).Scan(&role.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, addRolePermissionsQuery, role.ID, pq.Array(role.Permissions))
+	if err != nil {
+		return err
+	}
+
+	// Nobody has the new role yet, so no cached permissions need dropping
+	return tx.Commit()
+}
+
+// Delete removes a role. Users who had the role lose the permissions it bundled.
+func (m RoleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM roles
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	// Any number of users may have had this role
+	m.Cache.invalidateAllPermissions()
+	return nil
+}
+
+// AddPermissions adds permission codes to a role. Codes the role already has are ignored.
+func (m RoleModel) AddPermissions(roleID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, addRolePermissionsQuery, roleID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	m.Cache.invalidateAllPermissions()
+	return nil
+}
+
+// RemovePermissions removes permission codes from a role. Codes the role doesn't have are ignored.
+func (m RoleModel) RemovePermissions(roleID int64, codes ...string) error {
+	query := `
+	DELETE FROM roles_permissions
+	USING permissions
+	WHERE roles_permissions.permission_id = permissions.id
+	AND roles_permissions.role_id = $1
+	AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, roleID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	m.Cache.invalidateAllPermissions()
+	return nil
+}
+
+// GetAllForUser returns the names of the roles assigned to a specific user
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+	SELECT roles.name
+	FROM roles
+	INNER JOIN users_roles ON users_roles.role_id = roles.id
+	WHERE users_roles.user_id = $1
+	ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+
+	for rows.Next() {
+		var role string
+
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// AddForUser assigns the named roles to a specific user. Roles the user already has are ignored.
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	query := `
+	INSERT INTO users_roles
+	SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+	ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	m.Cache.invalidatePermissions(userID)
+	return nil
+}
+
+// RemoveForUser unassigns the named roles from a specific user. Roles the user doesn't have are ignored.
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	query := `
+	DELETE FROM users_roles
+	USING roles
+	WHERE users_roles.role_id = roles.id
+	AND users_roles.user_id = $1
+	AND roles.name = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	m.Cache.invalidatePermissions(userID)
+	return nil
+}
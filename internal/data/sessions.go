@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Session describes where a user is logged in. Each login starts a new token family,
+// and the session lasts for as long as the family holds a usable refresh token.
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	Family     []byte    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Insert records a new session
+func (m SessionModel) Insert(session *Session) error {
+	query := `
+	INSERT INTO sessions (user_id, family, user_agent, ip)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, last_used_at`
+
+	args := []interface{}{session.UserID, session.Family, session.UserAgent, session.IP}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt)
+}
+
+// Touch marks a session as used, and records the client it was last used from
+func (m SessionModel) Touch(family []byte, userAgent, ip string) error {
+	query := `
+	UPDATE sessions
+	SET last_used_at = NOW(), user_agent = $2, ip = $3
+	WHERE family = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, family, userAgent, ip)
+	return err
+}
+
+// GetAllForUser returns the user's sessions which still have a usable refresh token,
+// most recently used first.
+func (m SessionModel) GetAllForUser(userID int64) ([]*Session, error) {
+	query := `
+	SELECT id, user_id, family, user_agent, ip, created_at, last_used_at
+	FROM sessions
+	WHERE user_id = $1
+	AND EXISTS (
+		SELECT 1 FROM tokens
+		WHERE tokens.family = sessions.family
+		AND tokens.scope = $2 AND tokens.expiry > $3 AND NOT tokens.rotated
+	)
+	ORDER BY last_used_at DESC, id DESC`
+
+	return m.query(query, userID, ScopeRefresh, time.Now())
+}
+
+// GetAllStoredForUser returns every session stored for the user, most recently used
+// first. Unlike GetAllForUser it includes sessions which have ended but haven't been
+// cleaned up yet.
+func (m SessionModel) GetAllStoredForUser(userID int64) ([]*Session, error) {
+	query := `
+	SELECT id, user_id, family, user_agent, ip, created_at, last_used_at
+	FROM sessions
+	WHERE user_id = $1
+	ORDER BY last_used_at DESC, id DESC`
+
+	return m.query(query, userID)
+}
+
+// query runs a query which selects sessions and scans the results
+func (m SessionModel) query(query string, args ...interface{}) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.Family,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete removes one of the user's sessions and returns it, so the caller can revoke
+// the session's tokens.
+func (m SessionModel) Delete(id int64, userID int64) (*Session, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM sessions
+	WHERE id = $1 AND user_id = $2
+	RETURNING id, user_id, family, user_agent, ip, created_at, last_used_at`
+
+	var session Session
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Family,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+// DeleteOrphaned deletes up to batchSize sessions which have no tokens left, and returns
+// how many were deleted. Sessions which were used recently are skipped, so a session
+// isn't deleted in the moment between being created and having its tokens inserted.
+func (m SessionModel) DeleteOrphaned(batchSize int) (int64, error) {
+	query := `
+	DELETE FROM sessions
+	WHERE id IN (
+		SELECT id FROM sessions
+		WHERE last_used_at < $1
+		AND NOT EXISTS (SELECT 1 FROM tokens WHERE tokens.family = sessions.family)
+		LIMIT $2
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-time.Hour), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/base32"
 	"crypto/rand"
+	"errors"
 	"time"
 
 	"github.com/ahojo/greenlight/internal/validator"
@@ -15,6 +16,16 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
+	ScopeRefresh        = "refresh"
+	ScopeTOTPChallenge  = "totp-challenge"
+)
+
+var (
+	// ErrTokenReused is returned when a refresh token which has already been rotated
+	// is presented again, which means a copy of it has leaked.
+	ErrTokenReused = errors.New("token already used")
 )
 
 // Token hold the data for a token
@@ -25,6 +36,15 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// Family links the authentication and refresh tokens issued by one login,
+	// including every later rotation, so they can be revoked together.
+	Family []byte `json:"-"`
+}
+
+// TokenMetadata describes a stored token without exposing its hash.
+type TokenMetadata struct {
+	Scope  string    `json:"scope"`
+	Expiry time.Time `json:"expiry"`
 }
 
 func generateToken(UserID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -81,7 +101,8 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *Cache
 }
 
 // New() shortcut to create a new Token struct and insert the data into the database
@@ -96,13 +117,38 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewInFamily() works like New(), but adds the token to a token family
+func (m TokenModel) NewInFamily(userID int64, ttl time.Duration, scope string, family []byte) (*Token, error) {
+
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = family
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// NewTokenFamily generates a random identifier for a new token family
+func NewTokenFamily() ([]byte, error) {
+	family := make([]byte, 16)
+
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+
+	return family, nil
+}
+
 // Insert adds the data for a specific token to the tokens table
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4);
+	INSERT INTO tokens (hash, user_id, expiry, scope, family)
+	VALUES ($1, $2, $3, $4, $5);
 	`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -121,5 +167,211 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Revoked tokens must stop working straight away, not when the cache expires
+	m.Cache.invalidateUser(userID)
+	return nil
+}
+
+// Delete deletes a single token, identified by its scope and plaintext value.
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+
+	// Tokens are only stored as SHA-256 hashes, so hash the plaintext before the lookup.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2
+		RETURNING user_id;
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	m.Cache.invalidateToken(tokenHash[:], userID)
+	return nil
+}
+
+// GetAllForUser returns the metadata of every unexpired token belonging to a user.
+func (m TokenModel) GetAllForUser(userID int64) ([]*TokenMetadata, error) {
+	query := `
+		SELECT scope, expiry
+		FROM tokens
+		WHERE user_id = $1 AND expiry > $2
+		ORDER BY expiry;
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*TokenMetadata{}
+
+	for rows.Next() {
+		var token TokenMetadata
+
+		err := rows.Scan(&token.Scope, &token.Expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DeleteFamily deletes a token together with every token in the same family. Tokens
+// without a family are deleted on their own.
+func (m TokenModel) DeleteFamily(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1
+		OR family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2)
+		RETURNING user_id;
+	`
+	return m.deleteReturningUsers(query, tokenHash[:], scope)
+}
+
+// DeleteFamilyByID deletes every token in a token family
+func (m TokenModel) DeleteFamilyByID(family []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1
+		RETURNING user_id;
+	`
+	return m.deleteReturningUsers(query, family)
+}
+
+// deleteReturningUsers runs a DELETE ... RETURNING user_id query and drops the cached
+// entries of every user whose tokens were deleted. It returns ErrRecordNotFound if
+// nothing was deleted.
+func (m TokenModel) deleteReturningUsers(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	deleted := false
+
+	for rows.Next() {
+		var userID int64
+
+		err := rows.Scan(&userID)
+		if err != nil {
+			return err
+		}
+
+		deleted = true
+		m.Cache.invalidateUser(userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if !deleted {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Rotate marks a refresh token as used and returns it, so that a new token can be
+// issued in its place. Rotated tokens are kept until they expire: if one is presented
+// again Rotate returns ErrTokenReused, along with the token so its family can be revoked.
+func (m TokenModel) Rotate(tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE tokens SET rotated = true
+		WHERE hash = $1 AND scope = $2 AND expiry > $3 AND NOT rotated
+		RETURNING user_id, expiry, family;
+	`
+
+	token := Token{
+		Plaintext: tokenPlaintext,
+		Hash:      tokenHash[:],
+		Scope:     ScopeRefresh,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh, time.Now()).Scan(&token.UserID, &token.Expiry, &token.Family)
+	if err == nil {
+		return &token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// The token is unknown, expired or already rotated. Check for the last case.
+	query = `
+		SELECT user_id, expiry, family
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND rotated;
+	`
+
+	err = m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&token.UserID, &token.Expiry, &token.Family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, ErrTokenReused
+}
+
+// DeleteExpired deletes up to batchSize expired tokens of any scope, and returns how
+// many were deleted. Call it repeatedly until it deletes fewer than batchSize.
+func (m TokenModel) DeleteExpired(batchSize int) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE hash IN (
+			SELECT hash FROM tokens
+			WHERE expiry < $1
+			LIMIT $2
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
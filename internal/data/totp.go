@@ -0,0 +1,268 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/validator"
+)
+
+// The number of recovery codes issued when TOTP is enabled
+const recoveryCodeCount = 10
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+)
+
+// TOTP holds a user's two-factor authentication secret. The secret is saved as soon as
+// the user starts enrolment, but isn't Enabled until they confirm it with a code.
+type TOTP struct {
+	UserID      int64     `json:"-"`
+	Secret      string    `json:"-"`
+	Enabled     bool      `json:"enabled"`
+	LastCounter int64     `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Check that the code is a 6 digit TOTP code or a recovery code
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) <= 32, "code", "must not be more than 32 bytes long")
+}
+
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// Get returns the user's TOTP settings
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+	SELECT user_id, secret, enabled, last_counter, created_at
+	FROM users_totp
+	WHERE user_id = $1`
+
+	var totp TOTP
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastCounter,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// IsEnabled reports whether the user has confirmed TOTP enrolment
+func (m TOTPModel) IsEnabled(userID int64) (bool, error) {
+	totp, err := m.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return totp.Enabled, nil
+}
+
+// Start saves a new secret for a user who is enrolling. Starting again replaces the
+// secret, unless enrolment has already been confirmed.
+func (m TOTPModel) Start(userID int64, secret string) error {
+	query := `
+	INSERT INTO users_totp (user_id, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE
+	SET secret = EXCLUDED.secret, last_counter = 0, created_at = NOW()
+	WHERE NOT users_totp.enabled`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	return nil
+}
+
+// UseCounter records that the code for counter has been used, so it can't be replayed.
+// It returns false if a code for the same or a later counter has already been used.
+func (m TOTPModel) UseCounter(userID, counter int64) (bool, error) {
+	query := `
+	UPDATE users_totp
+	SET last_counter = $2
+	WHERE user_id = $1 AND last_counter < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, counter)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// Enable confirms enrolment and replaces the user's recovery codes with a fresh set.
+// The plaintext recovery codes are returned, and can't be retrieved again.
+func (m TOTPModel) Enable(userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE users_totp SET enabled = true WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		hash := hashRecoveryCode(code)
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hash[:], userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used. It returns false if
+// the code doesn't exist or has already been used.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	hash := hashRecoveryCode(code)
+
+	query := `
+	UPDATE recovery_codes
+	SET used_at = NOW()
+	WHERE hash = $1 AND user_id = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash[:], userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// Delete turns TOTP off for a user, and removes their recovery codes
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// generateRecoveryCode returns a random code in the format "xxxxx-xxxxx"
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 10)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case and the dash in the middle
+func hashRecoveryCode(code string) [32]byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return sha256.Sum256([]byte(code))
+}
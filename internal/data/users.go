@@ -5,10 +5,10 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/ahojo/greenlight/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -28,7 +28,10 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	// PendingEmail holds a new address which the user has asked to change to,
+	// but which hasn't been confirmed yet.
+	PendingEmail string `json:"pending_email,omitempty"`
+	Version      int    `json:"-"`
 }
 
 // password
@@ -48,10 +51,11 @@ func (u *User) IsAnonymous() bool {
 		otherUser.IsAnonymous() // → Returns false
 	*/
 }
-// Set() calculates the bcrypt hash of a plaintext password, stores the hash and plaintext versions in the struct
+// Set() calculates the hash of a plaintext password using the PasswordHashing settings,
+// stores the hash and plaintext versions in the struct
 func (p *password) Set(plaintextPassword string) error {
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := hashPassword(plaintextPassword, PasswordHashing)
 	if err != nil {
 		return err
 	}
@@ -64,17 +68,13 @@ func (p *password) Set(plaintextPassword string) error {
 // Matches() method checks whether the provided plaintext password matches the hashed password stored in the struct
 // returns: boolean
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
-	}
+	return comparePassword(p.hash, plaintextPassword)
+}
 
-	return true, nil
+// NeedsRehash() reports whether the hash was made with outdated settings, and should be
+// replaced by calling Set() with the plaintext password the next time it's known.
+func (p *password) NeedsRehash() (bool, error) {
+	return passwordNeedsRehash(p.hash, PasswordHashing)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -96,7 +96,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	// If the plaintext password is not nil call the validate function
 	if user.Password.plaintext != nil {
-		ValidatePassword(v, *user.Password.plaintext)
+		ValidateNewPassword(v, *user.Password.plaintext)
 	}
 
 	// If the password hash is ever nil, this will be due to a logic error in our codebase
@@ -110,7 +110,8 @@ func ValidateUser(v *validator.Validator, user *User) {
 /* USERMODEL to interact with the database */
 
 type UserModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *Cache
 }
 
 // Insert add a user to the database
@@ -145,7 +146,7 @@ func (m UserModel) Insert(user *User) error {
 // We have a UNIQUE constraint so only 1 row will be returned
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-        SELECT id, created_at, name, email, password_hash, activated, version
+        SELECT id, created_at, name, email, password_hash, activated, COALESCE(pending_email, ''), version
         FROM users
         WHERE email = $1`
 
@@ -160,6 +161,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
 		&user.Version,
 	)
 	if err != nil {
@@ -181,14 +183,15 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
         UPDATE users 
-        SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-        WHERE id = $5 AND version = $6
+        SET name = $1, email = $2, password_hash = $3, activated = $4, pending_email = NULLIF($5, ''), version = version + 1
+        WHERE id = $6 AND version = $7
         RETURNING version`
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.PendingEmail,
 		user.ID,
 		user.Version,
 	}
@@ -206,6 +209,9 @@ func (m UserModel) Update(user *User) error {
 			return err
 		}
 	}
+
+	// Make sure the next authenticated request sees the updated user
+	m.Cache.invalidateUser(user.ID)
 	return nil
 }
 
@@ -215,9 +221,30 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// This return a byte array with len 32, not a slice
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
-	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version FROM users 
+	// Authentication tokens are looked up on every request, so check the cache first
+	if tokenScope == ScopeAuthentication {
+		if user, ok := m.Cache.getUser(tokenHash[:]); ok {
+			return user, nil
+		}
+	}
+
+	// Taken before the query, so a result which raced with the token being revoked
+	// isn't cached
+	generation := m.Cache.snapshot()
+
+	// The session the token belongs to is marked as used at the same time. This only
+	// happens on a cache miss, which keeps last_used_at accurate to within the cache TTL
+	// without writing to the database on every request.
+	query := `
+	WITH session AS (
+		UPDATE sessions SET last_used_at = NOW()
+		FROM tokens
+		WHERE sessions.family = tokens.family
+		AND tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3 AND NOT tokens.rotated
+	)
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, COALESCE(users.pending_email, ''), users.version, tokens.expiry FROM users 
 	INNER JOIN tokens ON users.id = tokens.user_id 
-	WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3`
+	WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3 AND NOT tokens.rotated`
 
 	// Create a slice containing the query args
 	// use the [:] operator to get  a slice containing the token hash, the array tokenHash is not supported by pq
@@ -225,6 +252,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
 	var user User
+	var tokenExpiry time.Time
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -236,7 +264,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
 		&user.Version,
+		&tokenExpiry,
 	)
 
 	if err != nil {
@@ -248,7 +278,134 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		}
 	}
 
+	if tokenScope == ScopeAuthentication {
+		m.Cache.setUser(tokenHash[:], &user, tokenExpiry, generation)
+	}
+
 	// Return the user
 	return &user, nil
 }
 
+
+// Delete removes a user. The tokens and users_permissions tables reference users with
+// ON DELETE CASCADE, so the user's tokens and permissions are removed along with them.
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM users
+        WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	m.Cache.invalidateUser(id)
+	return nil
+}
+
+// Get retrieves a specific user by ID
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, created_at, name, email, password_hash, activated, COALESCE(pending_email, ''), version
+        FROM users
+        WHERE id = $1`
+
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.PendingEmail,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// GetAll returns a paginated slice of users. The name and email filters are optional
+// case-insensitive substring matches, and are skipped when they are the empty string.
+// They're matched with strpos() rather than ILIKE, so % and _ in a filter are literal.
+func (m UserModel) GetAll(name string, email string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), id, created_at, name, email, password_hash, activated, COALESCE(pending_email, ''), version
+        FROM users
+        WHERE (strpos(lower(name), lower($1)) > 0 OR $1 = '')
+        AND (strpos(lower(email), lower($2)) > 0 OR $2 = '')
+        ORDER BY %s %s, id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{name, email, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.PendingEmail,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ahojo/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Watchlist statuses
+const (
+	WatchlistToWatch = "to-watch"
+	WatchlistWatched = "watched"
+)
+
+// WatchlistEntry is a movie on a user's watchlist
+type WatchlistEntry struct {
+	UserID    int64      `json:"-"`
+	Movie     *Movie     `json:"movie"`
+	Status    string     `json:"status"`
+	AddedAt   time.Time  `json:"added_at"`
+	WatchedAt *time.Time `json:"watched_at,omitempty"`
+}
+
+func ValidateWatchlistEntry(v *validator.Validator, entry *WatchlistEntry) {
+	v.Check(entry.Movie.ID > 0, "movie_id", "must be provided")
+
+	v.Check(validator.In(entry.Status, WatchlistToWatch, WatchlistWatched), "status", "must be to-watch or watched")
+
+	if entry.WatchedAt != nil {
+		v.Check(entry.Status == WatchlistWatched, "watched_at", "must only be provided for watched movies")
+		v.Check(!entry.WatchedAt.After(time.Now()), "watched_at", "must not be in the future")
+	}
+}
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Upsert adds a movie to the user's watchlist, or updates the entry if the movie is
+// already on it. The time it was first added is kept.
+func (m WatchlistModel) Upsert(entry *WatchlistEntry) error {
+	query := `
+	INSERT INTO watchlist (user_id, movie_id, status, watched_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (user_id, movie_id) DO UPDATE
+	SET status = EXCLUDED.status, watched_at = EXCLUDED.watched_at
+	RETURNING added_at`
+
+	args := []interface{}{entry.UserID, entry.Movie.ID, entry.Status, entry.WatchedAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.AddedAt)
+}
+
+// GetAllForUser returns a page of the user's watchlist, optionally only the entries
+// with the given status. When filters has no page size, the whole watchlist is
+// returned and the metadata is empty.
+func (m WatchlistModel) GetAllForUser(userID int64, status string, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	orderBy := "watchlist.added_at DESC"
+	if filters.Sort != "" {
+		orderBy = fmt.Sprintf("%s %s", filters.sortColumn(), filters.sortDirection())
+	}
+
+	// LIMIT NULL means no limit
+	var limit interface{}
+	if filters.PageSize > 0 {
+		limit = filters.limit()
+	}
+
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) OVER(), watchlist.user_id, watchlist.status, watchlist.added_at, watchlist.watched_at,
+		movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+		ratings.average, ratings.count
+	FROM watchlist
+	INNER JOIN movies ON movies.id = watchlist.movie_id
+	CROSS JOIN LATERAL (`+ratingsSubquery+`) AS ratings
+	WHERE watchlist.user_id = $1
+	AND (watchlist.status = $2 OR $2 = '')
+	ORDER BY %s, movies.id ASC
+	LIMIT $3 OFFSET $4`, orderBy)
+
+	args := []interface{}{userID, status, limit, 0}
+	if filters.PageSize > 0 {
+		args[3] = filters.offset()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		entry := WatchlistEntry{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.UserID,
+			&entry.Status,
+			&entry.AddedAt,
+			&entry.WatchedAt,
+			&entry.Movie.ID,
+			&entry.Movie.CreatedAt,
+			&entry.Movie.Title,
+			&entry.Movie.Year,
+			&entry.Movie.Runtime,
+			pq.Array(&entry.Movie.Genres),
+			&entry.Movie.Version,
+			&entry.Movie.AverageRating,
+			&entry.Movie.ReviewCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var metadata Metadata
+	if filters.PageSize > 0 {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	return entries, metadata, nil
+}
+
+// Delete removes a movie from the user's watchlist
+func (m WatchlistModel) Delete(userID, movieID int64) error {
+	if movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM watchlist
+	WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
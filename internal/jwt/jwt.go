@@ -0,0 +1,247 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Supported signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+)
+
+// encoding is the unpadded base64url encoding which JWTs use for every segment
+var encoding = base64.RawURLEncoding
+
+// Claims are the claims we put into, and expect back from, a signed token
+type Claims struct {
+	Subject     string   `json:"sub"`
+	ID          string   `json:"jti"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+	Family      string   `json:"fam,omitempty"` // The token family of the matching refresh token
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Key is a named signing key. A key loaded from an Ed25519 public key can only be
+// used to verify tokens, which is useful for keeping a retired key around until the
+// tokens it signed have expired.
+type Key struct {
+	ID        string
+	Algorithm string
+
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// LoadKey reads a key from a file. For HS256 the file holds the raw secret, which must
+// be at least 32 bytes. For EdDSA the file holds a PEM encoded PKCS #8 private key, or a
+// PKIX public key for a verify-only key.
+func LoadKey(id, algorithm, path string) (*Key, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: id, Algorithm: algorithm}
+
+	switch algorithm {
+	case AlgorithmHS256:
+		key.secret = bytes.TrimSpace(contents)
+		if len(key.secret) < 32 {
+			return nil, fmt.Errorf("jwt key %q: HS256 secret must be at least 32 bytes", id)
+		}
+
+	case AlgorithmEdDSA:
+		block, _ := pem.Decode(contents)
+		if block == nil {
+			return nil, fmt.Errorf("jwt key %q: no PEM data found", id)
+		}
+
+		switch block.Type {
+		case "PRIVATE KEY":
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", id, err)
+			}
+			privateKey, ok := parsed.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("jwt key %q: not an Ed25519 private key", id)
+			}
+			key.privateKey = privateKey
+			key.publicKey = privateKey.Public().(ed25519.PublicKey)
+
+		case "PUBLIC KEY":
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", id, err)
+			}
+			publicKey, ok := parsed.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("jwt key %q: not an Ed25519 public key", id)
+			}
+			key.publicKey = publicKey
+
+		default:
+			return nil, fmt.Errorf("jwt key %q: unsupported PEM block type %q", id, block.Type)
+		}
+
+	default:
+		return nil, fmt.Errorf("jwt key %q: unsupported algorithm %q", id, algorithm)
+	}
+
+	return key, nil
+}
+
+// canSign reports whether the key holds the secret or private key needed to sign
+func (k *Key) canSign() bool {
+	return k.secret != nil || k.privateKey != nil
+}
+
+func (k *Key) sign(input []byte) []byte {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	default:
+		return ed25519.Sign(k.privateKey, input)
+	}
+}
+
+func (k *Key) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		return hmac.Equal(k.sign(input), signature)
+	default:
+		return ed25519.Verify(k.publicKey, input, signature)
+	}
+}
+
+// Keyring holds every key we accept tokens from, and the active key we sign new
+// tokens with. Tokens name their key in the "kid" header, so keys can be rotated by
+// adding a new active key while keeping the old one until its tokens have expired.
+type Keyring struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+// NewKeyring returns a Keyring which signs with the key named activeID
+func NewKeyring(activeID string, keys ...*Key) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*Key)}
+
+	for _, key := range keys {
+		if _, exists := k.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt key %q: duplicate key id", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+
+	active, ok := k.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("jwt key %q: active key not found", activeID)
+	}
+	if !active.canSign() {
+		return nil, fmt.Errorf("jwt key %q: active key can't be used for signing", activeID)
+	}
+	k.active = active
+
+	return k, nil
+}
+
+// Sign returns a signed token containing the claims
+func (k *Keyring) Sign(claims Claims) (string, error) {
+	h, err := json.Marshal(header{Algorithm: k.active.Algorithm, Type: "JWT", KeyID: k.active.ID})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+	signature := k.active.sign([]byte(input))
+
+	return input + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify checks the token's signature and expiry, and returns its claims
+func (k *Keyring) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerJSON, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := json.Unmarshal(headerJSON, &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := k.keys[h.KeyID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	// Only accept the algorithm configured for the key, never the one the token
+	// asks for, so a token can't get itself verified in an unexpected way.
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	claimsJSON, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,251 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes contents to a file in a temporary directory and returns its path
+func writeFile(t *testing.T, name string, contents []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newHS256Key(t *testing.T, id string) *Key {
+	t.Helper()
+
+	key, err := LoadKey(id, AlgorithmHS256, writeFile(t, id, []byte(strings.Repeat("s", 32)+id)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newEdDSAKeys returns a signing key and a verify-only key for the same Ed25519 key pair
+func newEdDSAKeys(t *testing.T, id string) (*Key, *Key) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private, err := LoadKey(id, AlgorithmEdDSA, writeFile(t, id+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := LoadKey(id, AlgorithmEdDSA, writeFile(t, id+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return private, public
+}
+
+func newKeyring(t *testing.T, activeID string, keys ...*Key) *Keyring {
+	t.Helper()
+
+	keyring, err := NewKeyring(activeID, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func testClaims() Claims {
+	now := time.Now()
+
+	return Claims{
+		Subject:     "42",
+		ID:          "token-id",
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(time.Hour).Unix(),
+		Family:      "family",
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+	}
+}
+
+// encodeSegment encodes a value as a JWT header or claims segment
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoding.EncodeToString(js)
+}
+
+func TestRoundTrip(t *testing.T) {
+	edPrivate, _ := newEdDSAKeys(t, "ed")
+
+	tests := []struct {
+		name string
+		key  *Key
+	}{
+		{"HS256", newHS256Key(t, "hs")},
+		{"EdDSA", edPrivate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring := newKeyring(t, tt.key.ID, tt.key)
+			claims := testClaims()
+
+			token, err := keyring.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := keyring.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+
+			if got.Subject != claims.Subject || got.ID != claims.ID || got.ExpiresAt != claims.ExpiresAt ||
+				got.Family != claims.Family || !got.Activated || len(got.Permissions) != 1 || got.Permissions[0] != "movies:read" {
+				t.Errorf("Verify() = %+v; want %+v", got, claims)
+			}
+		})
+	}
+}
+
+// A retired key kept for verification accepts the tokens its private key signed
+func TestVerifyOnlyKey(t *testing.T) {
+	edPrivate, edPublic := newEdDSAKeys(t, "ed")
+
+	token, err := newKeyring(t, "ed", edPrivate).Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := newKeyring(t, "hs", newHS256Key(t, "hs"), edPublic)
+	if _, err := keyring.Verify(token); err != nil {
+		t.Errorf("Verify() with the public key error = %v", err)
+	}
+
+	if _, err := NewKeyring("ed", edPublic); err == nil {
+		t.Error("NewKeyring() accepted a verify-only key as the active key")
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	hs := newHS256Key(t, "hs")
+
+	if _, err := NewKeyring("missing", hs); err == nil {
+		t.Error("NewKeyring() accepted a missing active key")
+	}
+	if _, err := NewKeyring("hs", hs, newHS256Key(t, "hs")); err == nil {
+		t.Error("NewKeyring() accepted duplicate key IDs")
+	}
+}
+
+func TestLoadKeyShortSecret(t *testing.T) {
+	_, err := LoadKey("hs", AlgorithmHS256, writeFile(t, "hs", []byte("too short")))
+	if err == nil {
+		t.Error("LoadKey() accepted an HS256 secret shorter than 32 bytes")
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	hs := newHS256Key(t, "hs")
+	edPrivate, edPublic := newEdDSAKeys(t, "ed")
+	otherEd, _ := newEdDSAKeys(t, "ed")
+
+	keyring := newKeyring(t, "hs", hs, edPrivate)
+
+	valid, err := keyring.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, ".")
+
+	expiredClaims := testClaims()
+	expiredClaims.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	expired, err := keyring.Sign(expiredClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed by a different key which claims the same kid
+	impostor, err := newKeyring(t, "ed", otherEd).Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamperedClaims := testClaims()
+	tamperedClaims.Permissions = []string{"users:admin"}
+	tampered := parts[0] + "." + encodeSegment(t, tamperedClaims) + "." + parts[2]
+
+	// The classic algorithm confusion attack: an HS256 token for an EdDSA key, using
+	// the public key as the HMAC secret
+	confusedInput := encodeSegment(t, header{Algorithm: AlgorithmHS256, Type: "JWT", KeyID: "ed"}) + "." + parts[1]
+	mac := hmac.New(sha256.New, edPublic.publicKey)
+	mac.Write([]byte(confusedInput))
+	confused := confusedInput + "." + encoding.EncodeToString(mac.Sum(nil))
+
+	noneAlg := encodeSegment(t, header{Algorithm: "none", Type: "JWT", KeyID: "hs"}) + "." + parts[1] + "."
+
+	unknownKID := encodeSegment(t, header{Algorithm: AlgorithmHS256, Type: "JWT", KeyID: "missing"}) + "." + parts[1] + "." + parts[2]
+
+	badSignature := []byte(parts[2])
+	if badSignature[0] == 'A' {
+		badSignature[0] = 'B'
+	} else {
+		badSignature[0] = 'A'
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", expired, ErrExpiredToken},
+		{"unknown kid", unknownKID, ErrInvalidToken},
+		{"wrong key for kid", impostor, ErrInvalidToken},
+		{"algorithm mismatch", confused, ErrInvalidToken},
+		{"alg none", noneAlg, ErrInvalidToken},
+		{"tampered payload", tampered, ErrInvalidToken},
+		{"bad signature", parts[0] + "." + parts[1] + "." + string(badSignature), ErrInvalidToken},
+		{"missing signature", parts[0] + "." + parts[1] + ".", ErrInvalidToken},
+		{"too few segments", parts[0] + "." + parts[1], ErrInvalidToken},
+		{"too many segments", valid + ".extra", ErrInvalidToken},
+		{"header not base64", "!!!." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"empty", "", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := keyring.Verify(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %+v, %v; want error %v", claims, err, tt.want)
+			}
+		})
+	}
+}
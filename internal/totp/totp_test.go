@@ -0,0 +1,158 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret from RFC 6238 Appendix B, "12345678901234567890",
+// base-32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC 6238 Appendix B test vectors for SHA-1. The RFC lists 8 digit codes, and a 6
+// digit code is the last 6 digits of the 8 digit one.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, tt := range rfcVectors {
+		want := tt.code[len(tt.code)-Digits:]
+
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("Code() at %d = %q; want %q", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	got, err := Code(strings.ToLower(rfcSecret), Counter(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Errorf("Code() with a lowercase secret = %q, %v; want %q, nil", got, err, "287082")
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base-32!", 1); err == nil {
+		t.Error("Code() accepted an invalid secret")
+	}
+}
+
+func TestValidateRFC6238(t *testing.T) {
+	for _, tt := range rfcVectors {
+		at := time.Unix(tt.unix, 0)
+
+		counter, ok := Validate(tt.code[len(tt.code)-Digits:], rfcSecret, at)
+		if !ok || counter != Counter(at) {
+			t.Errorf("Validate() at %d = %d, %t; want %d, true", tt.unix, counter, ok, Counter(at))
+		}
+	}
+}
+
+// Codes are accepted for Skew periods either side of the current one, and no further
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Counter(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current period", 0, true},
+		{"previous period", -1, true},
+		{"next period", 1, true},
+		{"two periods ago", -2, false},
+		{"two periods ahead", 2, false},
+		{"long ago", -100, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			counter, ok := Validate(code, rfcSecret, now)
+			if ok != tt.ok {
+				t.Fatalf("Validate() ok = %t; want %t", ok, tt.ok)
+			}
+			if ok && counter != current+tt.offset {
+				t.Errorf("Validate() counter = %d; want %d", counter, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		code   string
+		secret string
+	}{
+		{"wrong code", "000000", rfcSecret},
+		{"too short", "28708", rfcSecret},
+		{"too long", "2870820", rfcSecret},
+		{"8 digit code", "94287082", rfcSecret},
+		{"empty", "", rfcSecret},
+		{"invalid secret", "287082", "not base-32!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.code, tt.secret, now); ok {
+				t.Errorf("Validate(%q) accepted the code", tt.code)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 160 bits is 32 base-32 characters
+	if len(secret) != 32 {
+		t.Errorf("GenerateSecret() = %q; want 32 characters", secret)
+	}
+
+	if _, err := Code(secret, 1); err != nil {
+		t.Errorf("Code() with a generated secret error = %v", err)
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == secret {
+		t.Error("GenerateSecret() returned the same secret twice")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Greenlight", "alice@example.com", rfcSecret)
+	want := "otpauth://totp/Greenlight:alice@example.com?algorithm=SHA1&digits=6&issuer=Greenlight&period=30&secret=" + rfcSecret
+
+	if got != want {
+		t.Errorf("URI() = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,10 @@
+-- Wildcard permission codes, matched by Permissions.Include()
+INSERT INTO permissions (code)
+VALUES
+('movies:*'),
+('*');
+
+-- The admin role can do everything, including anything added later.
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = '*';
@@ -0,0 +1,3 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family bytea;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS rotated bool NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
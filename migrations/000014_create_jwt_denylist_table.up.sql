@@ -0,0 +1,10 @@
+-- Each entry either revokes a single signed token by its jti, or every token issued
+-- to a user before issued_before. Entries can be removed once expiry has passed,
+-- since by then the tokens they revoke have expired anyway.
+CREATE TABLE IF NOT EXISTS jwt_denylist (
+  id bigserial PRIMARY KEY,
+  jti text,
+  user_id bigint,
+  issued_before timestamp(0) with time zone,
+  expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE jwt_denylist DROP COLUMN IF EXISTS family;
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,15 @@
+-- A session is one login: the token family shared by its authentication and refresh tokens
+CREATE TABLE IF NOT EXISTS sessions (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  family bytea NOT NULL UNIQUE,
+  user_agent text NOT NULL DEFAULT '',
+  ip text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  last_used_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- Lets a whole session be denied in JWT mode
+ALTER TABLE jwt_denylist ADD COLUMN IF NOT EXISTS family bytea;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS users_totp (
+  user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+  secret text NOT NULL,
+  enabled bool NOT NULL DEFAULT false,
+  last_counter bigint NOT NULL DEFAULT 0,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  hash bytea PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
@@ -0,0 +1,7 @@
+-- Failed login attempts, keyed by "account:<email>" or "ip:<address>"
+CREATE TABLE IF NOT EXISTS login_failures (
+  key text PRIMARY KEY,
+  failures integer NOT NULL DEFAULT 0,
+  last_failure_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  locked_until timestamp(0) with time zone
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS reviews (
+  id bigserial PRIMARY KEY,
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  rating smallint NOT NULL,
+  text text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  version integer NOT NULL DEFAULT 1,
+  CONSTRAINT reviews_movie_id_user_id_key UNIQUE (movie_id, user_id),
+  CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 10)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+-- Moderators can edit and delete anybody's review
+INSERT INTO permissions (code)
+VALUES
+('reviews:moderate');
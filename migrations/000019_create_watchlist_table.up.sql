@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS watchlist (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  status text NOT NULL DEFAULT 'to-watch',
+  added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  watched_at timestamp(0) with time zone,
+  PRIMARY KEY (user_id, movie_id),
+  CONSTRAINT watchlist_status_check CHECK (status IN ('to-watch', 'watched'))
+);
+
+CREATE INDEX IF NOT EXISTS watchlist_movie_id_idx ON watchlist (movie_id);
@@ -0,0 +1,17 @@
+-- Writing reviews has its own permission, so an API key scoped to movies:read can't
+-- change its owner's reviews.
+INSERT INTO permissions (code)
+VALUES
+('reviews:write');
+
+-- Everybody who could read movies could already review them.
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, reviews_write.id
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+CROSS JOIN permissions AS reviews_write
+WHERE permissions.code = 'movies:read' AND reviews_write.code = 'reviews:write';
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name IN ('viewer', 'editor') AND permissions.code = 'reviews:write';